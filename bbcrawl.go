@@ -4,14 +4,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/jwdev42/bbcrawl/cmdline"
 	"github.com/jwdev42/bbcrawl/global"
 	"github.com/jwdev42/bbcrawl/libcrawl"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+// exit status if the crawl was stopped by a signal
+const exitInterrupted = 130
+
+// exit status if the crawl completed, but downloads failed
+const exitDownloadsFailed = 1
+
 var log = global.GetLogger()
 
 func eexit(err error) {
@@ -19,37 +28,79 @@ func eexit(err error) {
 	os.Exit(2)
 }
 
+// handleSignals stops the crawl on the first SIGINT or SIGTERM. Running downloads get the CrawlContext's grace period
+// to finish, they will be cancelled if the grace period expires or if a second signal arrives.
+func handleSignals(cc *libcrawl.CrawlContext, sigs chan os.Signal) {
+	sig := <-sigs
+	fmt.Fprintf(os.Stderr, "Received %s, waiting up to %s for running downloads to finish. Repeat to abort.\n", sig, cc.GracePeriod)
+	cc.Stop()
+	select {
+	case <-sigs:
+	case <-time.After(cc.GracePeriod):
+	}
+	//restore the default behaviour so that another signal terminates the process immediately
+	signal.Stop(sigs)
+	fmt.Fprintln(os.Stderr, "Aborting running downloads.")
+	cc.Abort()
+}
+
 func main() {
 	log.SetTimeFormat(time.RFC1123)
 	cmd, err := cmdline.Partition(os.Args)
-	if err != nil {
+	//a retry of failed downloads gets its pager and crawler from the crawl report
+	retry := errors.Is(err, cmdline.ErrNoPager) && cmd != nil && cmdline.HasFlag(cmd.GlobalFlags, "retry-failed")
+	if err != nil && !retry {
 		eexit(fmt.Errorf("Command line: %w", err))
 	}
 	workDir, err := os.Getwd()
 	if err != nil {
 		eexit(err)
 	}
-	cc, err := libcrawl.NewCrawlContext(cmd.Pager, cmd.Crawler, workDir)
-	if err != nil {
-		eexit(fmt.Errorf("CrawlContext: %w", err))
+	var cc *libcrawl.CrawlContext
+	if retry {
+		cc = libcrawl.NewRetryContext(workDir)
+	} else {
+		cc, err = libcrawl.NewCrawlContext(cmd.Pager, cmd.Crawler, workDir)
+		if err != nil {
+			eexit(fmt.Errorf("CrawlContext: %w", err))
+		}
 	}
 	err = cc.SetOptions(cmd.GlobalFlags)
 	if err != nil {
 		eexit(fmt.Errorf("Global flags: %w", err))
 	}
-	err = cc.Pager.SetOptions(cmd.PagerFlags)
-	if err != nil {
-		eexit(fmt.Errorf("Pager flags: %w", err))
+	if !retry {
+		err = cc.Pager.SetOptions(cmd.PagerFlags)
+		if err != nil {
+			eexit(fmt.Errorf("Pager flags: %w", err))
+		}
+		err = cc.SetUrl(cmd.Url)
+		if err != nil {
+			eexit(fmt.Errorf("Url: %w", err))
+		}
+		err = cc.Crawler.SetOptions(cmd.CrawlerFlags)
+		if err != nil {
+			eexit(fmt.Errorf("Crawler flags: %w", err))
+		}
 	}
-	err = cc.Pager.SetUrl(cmd.Url)
-	if err != nil {
-		eexit(fmt.Errorf("Url: %w", err))
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go handleSignals(cc, sigs)
+	err = libcrawl.Crawl(cc)
+	if errors.Is(err, libcrawl.ErrInterrupted) || cc.Aborted() {
+		if page := cc.ResumePage(); page > 0 && !retry {
+			if cc.Checkpointed() {
+				fmt.Fprintf(os.Stderr, "Crawl stopped at page %d, use \"-resume true\" or \"-start %d\" to resume.\n", page, page)
+			} else {
+				fmt.Fprintf(os.Stderr, "Crawl stopped at page %d, use \"-start %d\" to resume.\n", page, page)
+			}
+		}
+		os.Exit(exitInterrupted)
 	}
-	err = cc.Crawler.SetOptions(cmd.CrawlerFlags)
-	if err != nil {
-		eexit(fmt.Errorf("Crawler flags: %w", err))
+	if errors.Is(err, libcrawl.ErrDownloadsFailed) {
+		log.Error(err)
+		os.Exit(exitDownloadsFailed)
 	}
-	err = libcrawl.Crawl(cc)
 	if err != nil {
 		eexit(err)
 	}
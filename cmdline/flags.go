@@ -7,11 +7,14 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/jwdev42/bbcrawl/cmdline/attrs"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 type Boolean bool
@@ -262,10 +265,17 @@ func (v Attrs) String() string {
 	if v == nil {
 		return ""
 	}
+	//keys are sorted so that equal attributes give equal strings
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 	builder := new(strings.Builder)
 	elements := len(v)
 	element := 1
-	for key, vals := range v {
+	for _, key := range keys {
+		vals := v[key]
 		builder.WriteString(key)
 		builder.WriteByte(attrs_token_key_value_separator)
 		for i, val := range vals {
@@ -320,3 +330,177 @@ func (v *StringWhitelist) String() string {
 	}
 	return strings.Join(v.elems, v.delim)
 }
+
+// HostRates maps host names to a rate, it is set by a comma-separated list of "host=rate" pairs.
+type HostRates map[string]float64
+
+func (v HostRates) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return fmt.Errorf("%q is not a \"host=rate\" pair", pair)
+		}
+		rate, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return err
+		}
+		if rate <= 0 {
+			return fmt.Errorf("Host %q: rate must be greater than 0", kv[0])
+		}
+		v[kv[0]] = rate
+	}
+	return nil
+}
+
+func (v HostRates) String() string {
+	if v == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(v))
+	for host, rate := range v {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", host, strconv.FormatFloat(rate, 'g', -1, 64)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ByteSize is an amount of bytes. It can be set by an integer with an optional binary unit suffix like "512K", "2M" or "1G".
+type ByteSize int64
+
+var byteSizeUnits = map[byte]int64{
+	'K': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+	'T': 1 << 40,
+}
+
+func (b *ByteSize) Set(s string) error {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	num = strings.TrimSuffix(num, "B")
+	if len(num) == 0 {
+		return fmt.Errorf("Invalid byte size: %q", s)
+	}
+	unit := int64(1)
+	if u, ok := byteSizeUnits[num[len(num)-1]]; ok {
+		unit = u
+		num = num[:len(num)-1]
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid byte size: %q", s)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("Invalid byte size: %q", s)
+	}
+	if f < 0 {
+		return fmt.Errorf("Byte size must not be negative: %q", s)
+	}
+	//float64(math.MaxInt64) is rounded up to 2^63, which does not fit into an int64
+	if f*float64(unit) >= float64(math.MaxInt64) {
+		return fmt.Errorf("Byte size is too large: %q", s)
+	}
+	*b = ByteSize(f * float64(unit))
+	return nil
+}
+
+func (b *ByteSize) String() string {
+	if b == nil {
+		return ""
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+// MediaTypes is a comma-separated list of media types like "image/*,video/mp4".
+type MediaTypes struct {
+	Types []string
+}
+
+func (v *MediaTypes) Set(s string) error {
+	types := strings.Split(s, ",")
+	v.Types = make([]string, 0, len(types))
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		parts := strings.Split(t, "/")
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("%q is not a media type", t)
+		}
+		if parts[0] == "*" && parts[1] != "*" {
+			return fmt.Errorf("%q: a wildcard type needs a wildcard subtype", t)
+		}
+		v.Types = append(v.Types, t)
+	}
+	return nil
+}
+
+func (v *MediaTypes) String() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(v.Types, ",")
+}
+
+// Command is a command line that is split into words like a shell would do it. Words can be quoted by single or
+// double quotes, a backslash escapes the next character outside of single quotes. No other shell syntax is supported.
+type Command struct {
+	Args []string
+}
+
+func (v *Command) Set(s string) error {
+	args := make([]string, 0, 4)
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return fmt.Errorf("Unterminated quote or escape in command %q", s)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("Empty command")
+	}
+	v.Args = args
+	return nil
+}
+
+func (v *Command) String() string {
+	if v == nil {
+		return ""
+	}
+	quoted := make([]string, len(v.Args))
+	for i, arg := range v.Args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
@@ -5,6 +5,7 @@ package cmdline
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -196,3 +197,95 @@ func TestAttrs(t *testing.T) {
 	}
 	t.Logf("%s:\n\tInput: %q\n\tString(): %q\n", t.Name(), input, a.String())
 }
+
+func TestHostRates(t *testing.T) {
+	hr := make(HostRates)
+	if err := hr.Set("example.net=0.5,www.example.org=2"); err != nil {
+		t.Logf("%s: %v", t.Name(), err)
+		t.FailNow()
+	}
+	if hr["example.net"] != 0.5 || hr["www.example.org"] != 2 {
+		t.Errorf("%s: unexpected result %v", t.Name(), map[string]float64(hr))
+	}
+	for _, input := range []string{"", "example.net", "=1", "example.net=0", "example.net=-1", "example.net=fast"} {
+		if err := make(HostRates).Set(input); err == nil {
+			t.Errorf("%s: input %q should have caused an error", t.Name(), input)
+		}
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	var tests = map[string]int64{
+		"0":     0,
+		"100":   100,
+		"512K":  512 << 10,
+		"2M":    2 << 20,
+		"2mb":   2 << 20,
+		"1.5G":  3 << 29,
+		"1T":    1 << 40,
+		"  7k ": 7 << 10,
+		"8191T": 8191 << 40,
+	}
+	for k, v := range tests {
+		b := new(ByteSize)
+		if err := b.Set(k); err != nil {
+			t.Errorf("%s: input %q: %v", t.Name(), k, err)
+			continue
+		}
+		if int64(*b) != v {
+			t.Errorf("%s: input %q: expected %d, got %d", t.Name(), k, v, int64(*b))
+		}
+	}
+	for _, v := range []string{"", "M", "-1", "2X", "two", "NaN", "inf", "-Inf", "+InfK", "8388608T", "9223372036854775807", "1e30"} {
+		if err := new(ByteSize).Set(v); err == nil {
+			t.Errorf("%s: input %q should have caused an error", t.Name(), v)
+		}
+	}
+}
+
+func TestMediaTypes(t *testing.T) {
+	mt := new(MediaTypes)
+	if err := mt.Set("image/*, Video/MP4"); err != nil {
+		t.Logf("%s: %v", t.Name(), err)
+		t.FailNow()
+	}
+	if mt.String() != "image/*,video/mp4" {
+		t.Errorf("%s: expected %q, got %q", t.Name(), "image/*,video/mp4", mt.String())
+	}
+	for _, input := range []string{"", "image", "image/", "/png", "*/png", "image/png/x"} {
+		if err := new(MediaTypes).Set(input); err == nil {
+			t.Errorf("%s: input %q should have caused an error", t.Name(), input)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	var tests = []struct {
+		input string
+		args  []string
+	}{
+		{"convert {path} -resize 50% out.jpg", []string{"convert", "{path}", "-resize", "50%", "out.jpg"}},
+		{`sh -c 'echo "$BBCRAWL_PATH"'`, []string{"sh", "-c", `echo "$BBCRAWL_PATH"`}},
+		{`mv "my file" dir\ name ''`, []string{"mv", "my file", "dir name", ""}},
+		{`a"b"'c'\'`, []string{"abc'"}},
+	}
+	for _, test := range tests {
+		cmd := new(Command)
+		if err := cmd.Set(test.input); err != nil {
+			t.Errorf("%s: %q: %v", t.Name(), test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmd.Args, test.args) {
+			t.Errorf("%s: %q: expected %q, got %q", t.Name(), test.input, test.args, cmd.Args)
+		}
+		again := new(Command)
+		if err := again.Set(cmd.String()); err != nil || !reflect.DeepEqual(again.Args, cmd.Args) {
+			t.Errorf("%s: %q does not survive String(): %q", t.Name(), test.input, cmd.String())
+		}
+	}
+	for _, input := range []string{"", "  ", `echo "unterminated`, `echo \`} {
+		if err := new(Command).Set(input); err == nil {
+			t.Errorf("%s: input %q should have caused an error", t.Name(), input)
+		}
+	}
+}
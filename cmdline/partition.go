@@ -9,6 +9,10 @@ import (
 	"strings"
 )
 
+// ErrNoPager is returned by Partition if the command line has no pager. If "-pager" is missing completely,
+// Partition returns a Product that contains all arguments as global flags along with the error.
+var ErrNoPager = errors.New("No pager found")
+
 type Product struct {
 	GlobalFlags  []string
 	Crawler      string
@@ -65,8 +69,10 @@ func Partition(cmdln []string) (*Product, error) {
 	args := cmdln[1:]
 	var index int
 
-	if index = findItem("-pager", args); index < 0 || oor(index+1, args) {
-		return nil, errors.New("No pager found")
+	if index = findItem("-pager", args); index < 0 {
+		return &Product{GlobalFlags: args}, ErrNoPager
+	} else if oor(index+1, args) {
+		return nil, ErrNoPager
 	}
 	product.GlobalFlags = args[0:index]
 	product.Pager = args[index+1]
@@ -99,3 +105,17 @@ func Partition(cmdln []string) (*Product, error) {
 	}
 	return product, nil
 }
+
+// HasFlag returns true if args contain the flag name as "-name", "--name", "-name=value" or "--name=value".
+func HasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if arg == name || strings.HasPrefix(arg, name+"=") {
+			return true
+		}
+	}
+	return false
+}
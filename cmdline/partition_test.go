@@ -4,7 +4,9 @@
 package cmdline
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -51,3 +53,30 @@ func TestPartition(t *testing.T) {
 	testPartitionPositive(t)
 	testPartitionErrors(t)
 }
+
+func TestPartitionGlobalOnly(t *testing.T) {
+	res, err := Partition([]string{"bbcrawl", "-o", "out", "-retry-failed", "report.json"})
+	if !errors.Is(err, ErrNoPager) {
+		t.Fatalf("Expected ErrNoPager, got %v", err)
+	}
+	if want := []string{"-o", "out", "-retry-failed", "report.json"}; !reflect.DeepEqual(res.GlobalFlags, want) {
+		t.Errorf("Expected global flags %q, got %q", want, res.GlobalFlags)
+	}
+}
+
+func TestHasFlag(t *testing.T) {
+	var tests = []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"-o", "out", "-retry-failed", "report.json"}, true},
+		{[]string{"--retry-failed=report.json"}, true},
+		{[]string{"-retry-failed-x", "report.json"}, false},
+		{[]string{"retry-failed"}, false},
+	}
+	for _, test := range tests {
+		if got := HasFlag(test.args, "retry-failed"); got != test.want {
+			t.Errorf("%q: expected %v, got %v", test.args, test.want, got)
+		}
+	}
+}
@@ -6,6 +6,5 @@ require (
 	github.com/jwdev42/cookiefile v0.1.3
 	github.com/jwdev42/logger v0.2.1
 	golang.org/x/net v0.33.0
+	golang.org/x/text v0.21.0
 )
-
-require golang.org/x/text v0.21.0 // indirect
@@ -4,18 +4,37 @@
 package libcrawl
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/jwdev42/bbcrawl/cmdline"
 	"github.com/jwdev42/bbcrawl/global"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"github.com/jwdev42/bbcrawl/libcrawl/manifest"
+	"github.com/jwdev42/bbcrawl/libhttp/ratelimit"
+	"github.com/jwdev42/bbcrawl/libhttp/sigv4"
 	"github.com/jwdev42/cookiefile"
 	"github.com/jwdev42/logger"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 const DEFAULT_DL_JOBS = 5
 
+// DEFAULT_GRACE_PERIOD is the time running downloads get to finish after an interrupt was received.
+const DEFAULT_GRACE_PERIOD = 30 * time.Second
+
+// DEFAULT_S3_REGION is the region of the bucket downloads are uploaded to if no region was given.
+const DEFAULT_S3_REGION = "us-east-1"
+
+// ErrInterrupted is returned by Crawl if the crawl was stopped before the pager ran out of pages.
+var ErrInterrupted = errors.New("Crawl interrupted")
+
 var log = global.GetLogger()
 
 var pagers = map[string]func(*CrawlContext) PagerInterface{
@@ -28,6 +47,7 @@ var crawlers = map[string]func(*CrawlContext) (CrawlerInterface, error){
 	CRAWLER_VB_ATTACHMENTS: NewVBAttachmentCrawler,
 	CRAWLER_SRC:            NewSrcCrawler,
 	CRAWLER_FILE:           NewFileCrawler,
+	CRAWLER_POSTS:          NewPostsCrawler,
 }
 
 type PagerInterface interface {
@@ -38,6 +58,7 @@ type PagerInterface interface {
 }
 
 type CrawlerInterface interface {
+	Abort()
 	Crawl(*url.URL) error
 	Finish()
 	SetOptions([]string) error
@@ -45,26 +66,193 @@ type CrawlerInterface interface {
 }
 
 type CrawlContext struct {
-	output  string
-	Cookies []*http.Cookie
-	Pager   PagerInterface
-	Crawler CrawlerInterface
+	output        string
+	Cookies       []*http.Cookie
+	GracePeriod   time.Duration
+	Pager         PagerInterface
+	pagerName     string
+	Crawler       CrawlerInterface
+	crawlerName   string
+	delay         time.Duration //pause between two pages
+	jitter        time.Duration //maximum random time added to delay
+	limiter       *ratelimit.Limiter
+	maxRate       int64 //maximum combined download speed in bytes per second
+	minSize       int64
+	maxSize       int64
+	accept        []string //media type patterns
+	fixExt        bool
+	fsTarget      download.Target
+	onConflict    download.ConflictPolicy
+	metadata      download.Metadata
+	dedupe        download.DedupeMode
+	imageFilter   download.ImageFilter
+	similar       *similarImages //nil if similar images are not detected
+	exec          *execHook      //nil if no command runs after downloads
+	archive       string         //path of the archive file downloads are written to, empty if files are written to output
+	archiveFormat download.ArchiveFormat
+	s3            *download.S3Config //nil unless downloads are uploaded to object storage
+	sink          download.Sink      //nil unless an archive is written or downloads are uploaded
+	dryRun        *dryRun            //nil unless downloads are only listed
+	reportPath    string             //file the crawl report is written to, empty if the report is only summarised
+	report        *crawlReport       //nil during a dry run
+	useManifest   bool
+	refresh       bool //download files again even if the manifest knows them
+	revalidate    bool //download files known to the manifest conditionally instead of skipping them
+	manifest      *manifest.Manifest
+	resume        bool             //continue from the checkpoint in the output directory
+	checkpoint    *checkpoint      //nil if the crawl is not checkpointed
+	progress      *progressDisplay //nil if the progress is not shown
+	nameTemplate  *nameTemplate
+	thread        *url.URL  //url the pager was set up with
+	started       time.Time //start of the crawl
+	stop          chan struct{}
+	stopOnce      *sync.Once
+	mu            *sync.Mutex
+	aborted       bool
+	nextPage      int //first page that was not crawled because of an interrupt
+	cancelled     int //lowest page number of all cancelled downloads
+}
+
+// Stop tells Crawl to not request any more pages from the pager. Downloads that have already been dispatched
+// will still be finished. Stop can be called from any goroutine and more than once.
+func (cc *CrawlContext) Stop() {
+	cc.stopOnce.Do(func() { close(cc.stop) })
+}
+
+func (cc *CrawlContext) stopped() bool {
+	select {
+	case <-cc.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Abort stops the crawl and cancels all running downloads.
+func (cc *CrawlContext) Abort() {
+	cc.Stop()
+	cc.mu.Lock()
+	cc.aborted = true
+	cc.mu.Unlock()
+	cc.Crawler.Abort()
+}
+
+// Aborted returns true if Abort was called.
+func (cc *CrawlContext) Aborted() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.aborted
+}
+
+// noteCancelled records that a download found on the given page did not complete because of an abort.
+func (cc *CrawlContext) noteCancelled(page int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.cancelled == 0 || page < cc.cancelled {
+		cc.cancelled = page
+	}
+}
+
+// ResumePage returns the page an interrupted crawl should be restarted from. That is either the first page
+// that was not crawled or the first page that has a cancelled download, whichever comes first.
+// Returns 0 if there is nothing to resume.
+func (cc *CrawlContext) ResumePage() int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	page := cc.nextPage
+	if cc.cancelled > 0 && (page == 0 || cc.cancelled < page) {
+		page = cc.cancelled
+	}
+	return page
 }
 
 // Parse global options and attach them to the CrawlContext
 func (cc *CrawlContext) SetOptions(args []string) error {
 	flagSet := flag.NewFlagSet("GlobalOptions", flag.ContinueOnError)
-	outputDir := &cmdline.FSDirectory{}
-	flagSet.Var(outputDir, "o", "set the output directory")
+	output := flagSet.String("o", "", "set the output directory, an archive file ending in .zip, .cbz, .tar, .tar.gz or .tgz or an s3://bucket/prefix URL")
+	s3Endpoint := flagSet.String("s3-endpoint", "", "URL of the S3-compatible server, default is the AWS endpoint of the region")
+	s3Region := flagSet.String("s3-region", DEFAULT_S3_REGION, "region of the S3 bucket")
 	cf := flagSet.String("cookie-file", "", "load cookies from file")
 	loglevel := logger.LevelFlag(global.Default_Loglevel)
 	flagSet.Var(&loglevel, "loglevel", "set the least severe loglevel that will have its messages printed")
+	grace := flagSet.Duration("grace", DEFAULT_GRACE_PERIOD, "time running downloads get to finish after an interrupt")
+	delay := flagSet.Duration("delay", 0, "pause between loading two pages")
+	jitter := flagSet.Duration("jitter", 0, "maximum random time that is added to delay")
+	rate := flagSet.Float64("rate", 0, "maximum number of http requests per second, 0 means unlimited")
+	maxRate := new(cmdline.ByteSize)
+	flagSet.Var(maxRate, "max-rate", "maximum combined download speed in bytes per second, e.g. 2M")
+	minSize, maxSize := new(cmdline.ByteSize), new(cmdline.ByteSize)
+	flagSet.Var(minSize, "min-size", "skip downloads that are smaller than the given size")
+	flagSet.Var(maxSize, "max-size", "skip downloads that are larger than the given size")
+	accept := new(cmdline.MediaTypes)
+	flagSet.Var(accept, "accept", "comma-separated list of media types that will be downloaded, e.g. image/*,video/mp4")
+	minWidth := flagSet.Int("min-width", 0, "skip images that are narrower than the given number of pixels")
+	minHeight := flagSet.Int("min-height", 0, "skip images that are lower than the given number of pixels")
+	minAspect := flagSet.Float64("min-aspect", 0, "skip images whose width divided by their height is smaller than the given value")
+	maxAspect := flagSet.Float64("max-aspect", 0, "skip images whose width divided by their height is larger than the given value")
+	fixExt := new(cmdline.Boolean)
+	flagSet.Var(fixExt, "fix-ext", "correct file extensions that don't match the downloaded content")
+	nameTmpl := flagSet.String("name-template", "", "template for the paths of downloaded files, e.g. {thread}/{page:04}/{post}-{index}.{ext}")
+	onConflict := flagSet.String("on-conflict", "fail", "what to do if a file already exists: fail, skip, overwrite, rename or hash")
+	useManifest := new(cmdline.Boolean)
+	*useManifest = cmdline.Boolean(true)
+	flagSet.Var(useManifest, "manifest", "record completed downloads in the output directory and skip them in later crawls")
+	refresh := new(cmdline.Boolean)
+	flagSet.Var(refresh, "refresh", "download files again even if the manifest knows them")
+	revalidate := new(cmdline.Boolean)
+	flagSet.Var(revalidate, "revalidate", "ask the server whether files known to the manifest have changed instead of skipping them")
+	metadata := flagSet.String("metadata", "none", "store the origin of every download: none, sidecar or xattr")
+	dedupe := flagSet.String("dedupe", "off", "what to do with downloads whose content was downloaded before: off, drop, hardlink, symlink or report")
+	similar := flagSet.Int("similar", -1, "group images whose perceptual hashes differ in at most the given number of bits, -1 disables the detection")
+	similarReport := flagSet.String("similar-report", "", "file the groups of similar images are written to, default is "+DEFAULT_SIMILAR_REPORT+" in the output directory")
+	keepLargest := new(cmdline.Boolean)
+	flagSet.Var(keepLargest, "keep-largest", "delete all images of a group of similar images but the one with the largest resolution")
+	execCmd := new(cmdline.Command)
+	flagSet.Var(execCmd, "exec", "command that runs after every completed download, e.g. \"clamscan {path}\"")
+	execJobs := flagSet.Int("exec-jobs", DEFAULT_EXEC_JOBS, "maximum number of commands that run at the same time")
+	reportPath := flagSet.String("report", "", "write the outcome of every download and page to the given JSON file")
+	resume := new(cmdline.Boolean)
+	flagSet.Var(resume, "resume", "continue an interrupted crawl from the checkpoint in the output directory")
+	retryFailed := flagSet.String("retry-failed", "", "download the failed downloads of the given crawl report again instead of crawling a thread")
+	progress := flagSet.String("progress", PROGRESS_AUTO, "show the progress of the crawl: auto, on or off, auto shows it if stdout is a terminal")
+	dryRunFlag := new(cmdline.Boolean)
+	flagSet.Var(dryRunFlag, "dry-run", "load and parse pages, but only list the downloads instead of downloading them")
+	dryRunOutput := flagSet.String("dry-run-output", "", "write the list of a dry run as JSON lines to the given file, - is stdout")
+	fsTarget := flagSet.String("fs-target", "native", "sanitise file names for the given file system rules: native, posix, windows or portable")
+	hostRates := make(cmdline.HostRates)
+	flagSet.Var(hostRates, "host-rate", "comma-separated list of host=rate pairs that limit the requests per second for single hosts")
 	if err := flagSet.Parse(args); err != nil {
 		return err
 	}
-	if len(outputDir.Path) > 0 {
+	if format, ok := download.ParseArchiveFormat(*output); ok {
+		p, err := filepath.Abs(*output)
+		if err != nil {
+			return err
+		}
+		cc.archive, cc.archiveFormat = p, format
+	} else if bucket, prefix, ok := download.ParseS3URL(*output); ok {
+		cfg, err := newS3Config(bucket, prefix, *s3Endpoint, *s3Region)
+		if err != nil {
+			return err
+		}
+		cc.s3 = cfg
+	} else if len(*output) > 0 {
+		outputDir := &cmdline.FSDirectory{}
+		if err := outputDir.Set(*output); err != nil {
+			return err
+		}
 		cc.output = outputDir.Path
 	}
+	if len(*retryFailed) > 0 {
+		if cc.Pager != nil {
+			return fmt.Errorf("retry-failed must not be combined with a pager and a crawler")
+		}
+		if err := cc.setRetry(*retryFailed, len(*output) > 0); err != nil {
+			return fmt.Errorf("retry-failed: %w", err)
+		}
+	} else if cc.Pager == nil {
+		return fmt.Errorf("A pager and a crawler are required unless -retry-failed is given")
+	}
 	if len(*cf) > 0 {
 		cookies, err := cookiefile.Load(*cf)
 		if err != nil {
@@ -72,20 +260,139 @@ func (cc *CrawlContext) SetOptions(args []string) error {
 		}
 		cc.Cookies = cookies
 	}
+	if *grace < 0 {
+		return fmt.Errorf("grace: negative duration %s", *grace)
+	}
+	cc.GracePeriod = *grace
+	if *delay < 0 || *jitter < 0 {
+		return fmt.Errorf("delay and jitter must not be negative")
+	}
+	cc.delay, cc.jitter = *delay, *jitter
+	cc.maxRate = int64(*maxRate)
+	if *maxSize > 0 && *minSize > *maxSize {
+		return fmt.Errorf("min-size must not be greater than max-size")
+	}
+	cc.minSize, cc.maxSize = int64(*minSize), int64(*maxSize)
+	if *minWidth < 0 || *minHeight < 0 || *minAspect < 0 || *maxAspect < 0 {
+		return fmt.Errorf("Image dimensions must not be negative")
+	}
+	if *maxAspect > 0 && *minAspect > *maxAspect {
+		return fmt.Errorf("min-aspect must not be greater than max-aspect")
+	}
+	cc.imageFilter = download.ImageFilter{MinWidth: *minWidth, MinHeight: *minHeight, MinAspect: *minAspect, MaxAspect: *maxAspect}
+	cc.accept = accept.Types
+	cc.fixExt = bool(*fixExt)
+	target, err := download.ParseTarget(*fsTarget)
+	if err != nil {
+		return err
+	}
+	cc.fsTarget = target
+	policy, err := download.ParseConflictPolicy(*onConflict)
+	if err != nil {
+		return err
+	}
+	cc.onConflict = policy
+	meta, err := download.ParseMetadata(*metadata)
+	if err != nil {
+		return err
+	}
+	cc.metadata = meta
+	dedupeMode, err := download.ParseDedupeMode(*dedupe)
+	if err != nil {
+		return err
+	}
+	cc.dedupe = dedupeMode
+	if *execJobs < 1 {
+		return fmt.Errorf("exec-jobs: at least 1 job is required")
+	}
+	if len(execCmd.Args) > 0 {
+		cc.exec = newExecHook(execCmd.Args, *execJobs)
+	}
+	if *similar > 64 {
+		return fmt.Errorf("similar: distance %d is larger than the hash size of 64 bits", *similar)
+	}
+	if *similar >= 0 {
+		report := *similarReport
+		if report == "" {
+			report = filepath.Join(cc.output, DEFAULT_SIMILAR_REPORT)
+		}
+		cc.similar = &similarImages{maxDistance: *similar, keepLargest: bool(*keepLargest), report: report}
+	} else if *keepLargest {
+		return fmt.Errorf("keep-largest requires the option -similar")
+	}
+	if len(*reportPath) > 0 {
+		p, err := filepath.Abs(*reportPath)
+		if err != nil {
+			return err
+		}
+		cc.reportPath = p
+	}
+	if *dryRunFlag {
+		cc.dryRun = newDryRun(*dryRunOutput, cc.output)
+	} else if *dryRunOutput != "" {
+		return fmt.Errorf("dry-run-output requires the option -dry-run")
+	}
+	if *resume {
+		switch {
+		case len(*retryFailed) > 0:
+			return fmt.Errorf("resume must not be combined with retry-failed")
+		case cc.dryRun != nil:
+			return fmt.Errorf("resume must not be combined with dry-run")
+		case cc.archive != "":
+			return fmt.Errorf("resume: not supported for archives")
+		}
+	}
+	cc.resume = bool(*resume)
+	showProgress, err := parseProgressMode(*progress)
+	if err != nil {
+		return fmt.Errorf("progress: %w", err)
+	}
+	if showProgress && cc.dryRun == nil {
+		//a dry run prints its list to stdout
+		cc.progress = newProgressDisplay(os.Stdout)
+	}
+	cc.useManifest = bool(*useManifest)
+	cc.refresh = bool(*refresh)
+	cc.revalidate = bool(*revalidate)
+	if len(*nameTmpl) > 0 {
+		tmpl, err := parseNameTemplate(*nameTmpl)
+		if err != nil {
+			return err
+		}
+		cc.nameTemplate = tmpl
+	}
+	if *rate < 0 {
+		return fmt.Errorf("rate: %g is not a valid number of requests per second", *rate)
+	}
+	if *rate > 0 || len(hostRates) > 0 {
+		cc.limiter = ratelimit.NewLimiter(*rate, hostRates)
+	}
 	log.SetLevel(int(loglevel))
+	return cc.checkSinkOptions()
+}
+
+// SetUrl passes the thread url to the pager and keeps it for name templates.
+func (cc *CrawlContext) SetUrl(addr string) error {
+	if err := cc.Pager.SetUrl(addr); err != nil {
+		return err
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	cc.thread = u
 	return nil
 }
 
 func NewCrawlContext(pager string, crawler string, defaultDir string) (*CrawlContext, error) {
 	var err error
-	cc := &CrawlContext{
-		output: defaultDir,
-	}
+	cc := newCrawlContext(defaultDir)
 	newPager := pagers[pager]
 	if newPager == nil {
 		return nil, fmt.Errorf("Pager not found: %q", pager)
 	}
 	cc.Pager = newPager(cc)
+	cc.pagerName = pager
 	newCrawler := crawlers[crawler]
 	if newCrawler == nil {
 		return nil, fmt.Errorf("Crawler not found: %q", crawler)
@@ -94,20 +401,288 @@ func NewCrawlContext(pager string, crawler string, defaultDir string) (*CrawlCon
 	if err != nil {
 		return nil, err
 	}
+	cc.crawlerName = crawler
 
 	return cc, nil
 }
 
-func Crawl(cc *CrawlContext) error {
+// NewRetryContext returns a CrawlContext without pager and crawler. They are set up by SetOptions from the crawl
+// report given by the option -retry-failed.
+func NewRetryContext(defaultDir string) *CrawlContext {
+	return newCrawlContext(defaultDir)
+}
+
+func newCrawlContext(defaultDir string) *CrawlContext {
+	return &CrawlContext{
+		output:      defaultDir,
+		GracePeriod: DEFAULT_GRACE_PERIOD,
+		started:     time.Now(),
+		stop:        make(chan struct{}),
+		stopOnce:    new(sync.Once),
+		mu:          new(sync.Mutex),
+	}
+}
+
+// pause waits for the configured delay between two pages plus a random amount of up to jitter.
+// It returns early if the crawl gets stopped.
+func (cc *CrawlContext) pause(rnd *rand.Rand) {
+	d := cc.delay
+	if cc.jitter > 0 {
+		d += time.Duration(rnd.Int63n(int64(cc.jitter) + 1))
+	}
+	if d == 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-cc.stop:
+	}
+}
+
+// newS3Config returns the configuration for uploads to bucket. The credentials are read from the environment variables
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+func newS3Config(bucket, prefix, endpoint, region string) (*download.S3Config, error) {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3-endpoint: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("s3-endpoint: %q is not a http or https URL", endpoint)
+	}
+	cfg := &download.S3Config{
+		Endpoint: u,
+		Region:   region,
+		Bucket:   bucket,
+		Prefix:   prefix,
+		Credentials: sigv4.Credentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+	}
+	if cfg.Credentials.AccessKey == "" || cfg.Credentials.SecretKey == "" {
+		return nil, fmt.Errorf("S3: the environment variables AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return cfg, nil
+}
+
+// sinkName names the place downloads are written to in messages, if it is not the output directory.
+func (cc *CrawlContext) sinkName() string {
+	if cc.s3 != nil {
+		return "S3"
+	}
+	return "Archive"
+}
+
+// sinkKind names the kind of place downloads are written to in option errors, it is empty for the output directory.
+func (cc *CrawlContext) sinkKind() string {
+	switch {
+	case cc.archive != "":
+		return "archives"
+	case cc.s3 != nil:
+		return "object storage"
+	}
+	return ""
+}
+
+// checkSinkOptions disables or rejects the options that need the downloaded files to stay in the file system.
+func (cc *CrawlContext) checkSinkOptions() error {
+	what := cc.sinkKind()
+	if what == "" {
+		return nil
+	}
+	cc.useManifest = false
+	if cc.dedupe == download.DedupeHardlink || cc.dedupe == download.DedupeSymlink {
+		return fmt.Errorf("dedupe: %s is not supported for %s", cc.dedupe, what)
+	}
+	if cc.metadata == download.MetadataXattr {
+		return fmt.Errorf("metadata: %s is not supported for %s", cc.metadata, what)
+	}
+	if cc.metadata == download.MetadataSidecar && cc.archive != "" && cc.archiveFormat == download.ArchiveCBZ {
+		//comic book readers would show the sidecar files as pages
+		return fmt.Errorf("metadata: %s is not supported for cbz archives", cc.metadata)
+	}
+	if cc.similar != nil {
+		return fmt.Errorf("similar: not supported for %s", what)
+	}
+	return nil
+}
+
+// openSink creates the archive downloads are written to or connects to the bucket they are uploaded to, if one of
+// them was requested. The output directory becomes a staging directory that holds downloads until they are handed
+// over to the sink. It is created next to the archive or in the directory for temporary files.
+func (cc *CrawlContext) openSink() error {
+	if cc.archive == "" && cc.s3 == nil {
+		return nil
+	}
+	parent := os.TempDir()
+	if cc.archive != "" {
+		parent = filepath.Dir(cc.archive)
+	}
+	staging, err := os.MkdirTemp(parent, ".bbcrawl-staging-")
+	if err == nil {
+		staging, err = filepath.Abs(staging)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", cc.sinkName(), err)
+	}
+	var sink download.Sink
+	if cc.s3 != nil {
+		sink, err = download.NewS3Sink(*cc.s3, staging, cc.onConflict)
+	} else {
+		sink, err = download.NewArchiveSink(cc.archive, cc.archiveFormat, staging, cc.onConflict)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("%s: %w", cc.sinkName(), err)
+	}
+	cc.output = staging
+	cc.sink = sink
+	return nil
+}
+
+func (cc *CrawlContext) closeSink() error {
+	if cc.sink == nil {
+		return nil
+	}
+	if err := cc.sink.Close(); err != nil {
+		return fmt.Errorf("%s: %w", cc.sinkName(), err)
+	}
+	return nil
+}
+
+// openManifest loads the manifest of the output directory if the manifest is enabled. A dry run only loads an
+// existing manifest.
+func (cc *CrawlContext) openManifest() error {
+	if !cc.useManifest {
+		return nil
+	}
+	open := manifest.Open
+	if cc.dryRun != nil {
+		//a dry run must not create or repair the manifest file
+		open = manifest.Load
+	}
+	m, err := open(cc.output)
+	if err != nil {
+		return fmt.Errorf("Manifest: %w", err)
+	}
+	if n := m.Truncated(); n > 0 {
+		log.Warning(fmt.Sprintf("Manifest: removed a cut-off last line of %d bytes, the crawl was interrupted while it was written", n))
+	}
+	cc.manifest = m
+	return nil
+}
+
+func (cc *CrawlContext) closeManifest() {
+	if cc.manifest == nil {
+		return
+	}
+	if err := cc.manifest.Close(); err != nil {
+		log.Error(fmt.Errorf("Manifest: %w", err))
+	}
+}
+
+func (cc *CrawlContext) closeDryRun() {
+	if err := cc.dryRun.close(); err != nil {
+		log.Error(fmt.Errorf("Dry run: %w", err))
+	}
+}
+
+// finishReport prints the summary of the crawl report and writes the report file. It returns ErrDownloadsFailed
+// if a download failed.
+func (cc *CrawlContext) finishReport() error {
+	if err := cc.report.finish(os.Stdout); err != nil {
+		log.Error(fmt.Errorf("Report: %w", err))
+	}
+	if n := cc.report.failures(); n > 0 {
+		return fmt.Errorf("%w: %d", ErrDownloadsFailed, n)
+	}
+	return nil
+}
+
+// Crawl loads every page of the pager and lets the crawler dispatch the downloads it finds. The summary of the
+// crawl is printed at the end, ErrDownloadsFailed is returned if a download failed. The checkpoint is kept
+// while the crawl runs and removed once it completed.
+func Crawl(cc *CrawlContext) (err error) {
+	if cc.dryRun == nil {
+		cc.report = newCrawlReport(cc.reportPath, cc.output, cc.thread, cc.started)
+		defer func() {
+			if ferr := cc.finishReport(); err == nil {
+				err = ferr
+			}
+		}()
+	}
+	if cc.dryRun != nil {
+		if err := cc.dryRun.open(); err != nil {
+			return fmt.Errorf("Dry run: %w", err)
+		}
+		defer cc.closeDryRun()
+	}
+	cpPath := cc.checkpointPath()
+	if cc.dryRun == nil {
+		if err := cc.openSink(); err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := cc.closeSink(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	var prev *checkpoint
+	if cpPath != "" {
+		if prev, err = cc.openCheckpoint(cpPath); err != nil {
+			return err
+		}
+		defer func() { cc.closeCheckpoint(err == nil && !cc.Aborted()) }()
+	} else if cc.resume {
+		return fmt.Errorf("Resume: the crawl has no checkpoint")
+	}
+	if err := cc.openManifest(); err != nil {
+		return err
+	}
+	defer cc.closeManifest()
+	if cc.progress != nil {
+		cc.progress.start(cc.Pager)
+		defer cc.progress.close()
+	}
 	cc.Crawler.Setup()
 	defer cc.Crawler.Finish()
+	if prev != nil {
+		if err := cc.requeue(prev); err != nil {
+			return err
+		}
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	first := true
 	for url, err := cc.Pager.Next(); url != nil; {
 		if err != nil {
 			return err
 		}
+		if !first {
+			cc.pause(rnd)
+		}
+		first = false
+		if cc.stopped() {
+			cc.mu.Lock()
+			cc.nextPage = cc.Pager.PageNum()
+			cc.mu.Unlock()
+			return ErrInterrupted
+		}
+		if cc.progress != nil {
+			cc.progress.pageStarted(cc.Pager.PageNum())
+		}
 		if err := cc.Crawler.Crawl(url); err != nil {
 			return err
 		}
+		if t, ok := cc.Crawler.(interface{ pageCrawled(int) }); ok {
+			t.pageCrawled(cc.Pager.PageNum())
+		}
 		url, err = cc.Pager.Next()
 	}
 	return nil
@@ -0,0 +1,168 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stopPager stops the crawl as soon as the pager it wraps reaches page stopAt.
+type stopPager struct {
+	PagerInterface
+	cc     *CrawlContext
+	stopAt int
+}
+
+func (p *stopPager) Next() (*url.URL, error) {
+	u, err := p.PagerInterface.Next()
+	if p.PageNum() == p.stopAt {
+		p.cc.Stop()
+	}
+	return u, err
+}
+
+// newTestCrawl returns a CrawlContext for the file crawler that loads the pages from..to of srv. options are the
+// global options of the crawl.
+func newTestCrawl(t *testing.T, srv *httptest.Server, output, from, to string, options ...string) *CrawlContext {
+	cc, err := NewCrawlContext(PAGER_QUERY, CRAWLER_FILE, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.SetOptions(options); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Pager.SetOptions([]string{"-start", from, "-end", to}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Pager.SetUrl(srv.URL + "/file?page=" + from); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Crawler.SetOptions(nil); err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestStop(t *testing.T) {
+	var mu sync.Mutex
+	requested := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.RequestURI())
+		mu.Unlock()
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer srv.Close()
+	output := t.TempDir()
+	cc := newTestCrawl(t, srv, output, "1", "3")
+	cc.Pager = &stopPager{PagerInterface: cc.Pager, cc: cc, stopAt: 2}
+	if err := Crawl(cc); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("Expected ErrInterrupted, got %v", err)
+	}
+	//the download of the first page is still finished
+	if len(requested) != 1 || requested[0] != "/file?page=1" {
+		t.Errorf("Unexpected requests %v", requested)
+	}
+	if files, _ := filepath.Glob(filepath.Join(output, "1 - file*")); len(files) != 1 {
+		t.Errorf("Expected the file of the first page, got %v", files)
+	}
+	if cc.Aborted() {
+		t.Error("Stop aborted the crawl")
+	}
+	if page := cc.ResumePage(); page != 2 {
+		t.Errorf("Expected to resume at page 2, got %d", page)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial content"))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	output := t.TempDir()
+	cc := newTestCrawl(t, srv, output, "1", "1")
+	go func() {
+		<-started
+		cc.Abort()
+	}()
+	//the cancelled download counts as failed
+	if err := Crawl(cc); !errors.Is(err, ErrDownloadsFailed) {
+		t.Errorf("Expected ErrDownloadsFailed, got %v", err)
+	}
+	if !cc.Aborted() {
+		t.Error("Crawl was not aborted")
+	}
+	entries, err := os.ReadDir(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".part") || strings.HasPrefix(e.Name(), "1 - file") {
+			t.Errorf("File %q of the cancelled download was left behind", e.Name())
+		}
+	}
+	if page := cc.ResumePage(); page != 1 {
+		t.Errorf("Expected to resume at page 1, got %d", page)
+	}
+}
+
+func TestResumePage(t *testing.T) {
+	cc := &CrawlContext{mu: new(sync.Mutex)}
+	if page := cc.ResumePage(); page != 0 {
+		t.Errorf("Expected nothing to resume, got page %d", page)
+	}
+	cc.nextPage = 5
+	if page := cc.ResumePage(); page != 5 {
+		t.Errorf("Expected page 5, got %d", page)
+	}
+	cc.noteCancelled(4)
+	cc.noteCancelled(2)
+	cc.noteCancelled(3)
+	if page := cc.ResumePage(); page != 2 {
+		t.Errorf("Expected page 2, got %d", page)
+	}
+	cc.nextPage = 0
+	if page := cc.ResumePage(); page != 2 {
+		t.Errorf("Expected page 2 without a next page, got %d", page)
+	}
+	cc.nextPage = 1
+	if page := cc.ResumePage(); page != 1 {
+		t.Errorf("Expected page 1, got %d", page)
+	}
+}
+
+func TestSinkOptions(t *testing.T) {
+	dir := t.TempDir()
+	var tests = []struct {
+		options []string
+		valid   bool
+	}{
+		{[]string{"-o", filepath.Join(dir, "a.zip"), "-metadata", "sidecar"}, true},
+		{[]string{"-o", filepath.Join(dir, "a.cbz")}, true},
+		{[]string{"-o", filepath.Join(dir, "a.cbz"), "-metadata", "sidecar"}, false},
+		{[]string{"-o", filepath.Join(dir, "a.tar"), "-metadata", "xattr"}, false},
+		{[]string{"-o", filepath.Join(dir, "a.tar"), "-dedupe", "hardlink"}, false},
+	}
+	for _, test := range tests {
+		cc, err := NewCrawlContext(PAGER_QUERY, CRAWLER_FILE, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.SetOptions(test.options); (err == nil) != test.valid {
+			t.Errorf("%v: expected the options to be valid: %t, got %v", test.options, test.valid, err)
+		}
+	}
+}
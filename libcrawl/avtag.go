@@ -5,6 +5,7 @@ package libcrawl
 
 import (
 	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
 	"math/rand"
 	"net/url"
 	"path"
@@ -18,7 +19,7 @@ func (r avTag) addSrc(src string) error {
 	if err != nil {
 		return err
 	}
-	name := fileNameFromURL(u)
+	name := download.FileNameFromURL(u)
 	if name == "" {
 		return fmt.Errorf("Could not derive a filename from input path \"%s\"", u.Path)
 	}
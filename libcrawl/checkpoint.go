@@ -0,0 +1,219 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CHECKPOINT_FILE is the name of the checkpoint file inside of the output directory.
+const CHECKPOINT_FILE = ".bbcrawl-checkpoint.json"
+
+// resumablePager is implemented by pagers whose position can be saved in a checkpoint. position returns the state
+// of the pager between two calls of Next, seek restores it.
+type resumablePager interface {
+	position() int
+	seek(pos int)
+}
+
+// checkpointDownload is a download that was dispatched, but did not finish.
+type checkpointDownload struct {
+	seq int //dispatch order
+	d   *reportDownload
+}
+
+// checkpoint is the state of a running crawl. It is written to the output directory every time the crawler is done
+// with a page and every time all downloads of a page were collected. A crawl that is resumed from the checkpoint
+// continues after the last page the crawler was done with and dispatches the unfinished downloads again.
+type checkpoint struct {
+	mu          sync.Mutex
+	path        string
+	pager       resumablePager
+	Thread      string            `json:"thread"`
+	Pager       string            `json:"pager"`
+	Page        int               `json:"page"`     //last page the crawler was done with
+	Position    int               `json:"position"` //pager position after Page
+	Output      string            `json:"output"`   //directory the downloads are placed in
+	Updated     time.Time         `json:"updated"`
+	Downloads   []*reportDownload `json:"downloads"` //unfinished downloads in dispatch order
+	unfinished  map[*download.Download]*checkpointDownload
+	dispatchSeq int
+}
+
+func newCheckpoint(path string, cc *CrawlContext, pager resumablePager) *checkpoint {
+	cp := &checkpoint{
+		path:       path,
+		pager:      pager,
+		Pager:      cc.pagerName,
+		Output:     cc.output,
+		Downloads:  make([]*reportDownload, 0),
+		unfinished: make(map[*download.Download]*checkpointDownload),
+	}
+	if cc.thread != nil {
+		cp.Thread = cc.thread.String()
+	}
+	return cp
+}
+
+// loadCheckpoint reads the checkpoint at path.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(checkpoint)
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// dispatched adds dl to the unfinished downloads.
+func (cp *checkpoint) dispatched(dl *download.Download) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.dispatchSeq++
+	cp.unfinished[dl] = &checkpointDownload{seq: cp.dispatchSeq, d: newReportDownload(dl)}
+}
+
+// collected removes dl from the unfinished downloads, unless it was cancelled.
+func (cp *checkpoint) collected(dl *download.Download) {
+	if errors.Is(dl.Err, context.Canceled) {
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	delete(cp.unfinished, dl)
+}
+
+// pageCrawled notes that the crawler is done with page and saves the checkpoint.
+func (cp *checkpoint) pageCrawled(page int) {
+	cp.mu.Lock()
+	cp.Page, cp.Position = page, cp.pager.position()
+	cp.mu.Unlock()
+	cp.save()
+}
+
+// save writes the checkpoint file. The file is replaced atomically, so that a crash never leaves a broken checkpoint.
+func (cp *checkpoint) save() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.Page == 0 {
+		//there is nothing to resume before the first page was crawled
+		return
+	}
+	if err := cp.write(); err != nil {
+		log.Error(fmt.Errorf("Checkpoint: %w", err))
+	}
+}
+
+func (cp *checkpoint) write() error {
+	unfinished := make([]*checkpointDownload, 0, len(cp.unfinished))
+	for _, u := range cp.unfinished {
+		unfinished = append(unfinished, u)
+	}
+	sort.Slice(unfinished, func(i, j int) bool { return unfinished[i].seq < unfinished[j].seq })
+	cp.Downloads = make([]*reportDownload, len(unfinished))
+	for i, u := range unfinished {
+		cp.Downloads[i] = u.d
+	}
+	cp.Updated = time.Now()
+	b, err := json.MarshalIndent(cp, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+// remove deletes the checkpoint file after the crawl completed.
+func (cp *checkpoint) remove() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error(fmt.Errorf("Checkpoint: %w", err))
+	}
+}
+
+// checkpointPath returns the path of the checkpoint file, or an empty string if the crawl is not checkpointed.
+// Dry runs, retries and archives have no checkpoint.
+func (cc *CrawlContext) checkpointPath() string {
+	if _, ok := cc.Pager.(resumablePager); !ok || cc.dryRun != nil || cc.archive != "" {
+		return ""
+	}
+	return filepath.Join(cc.output, CHECKPOINT_FILE)
+}
+
+// openCheckpoint sets up the checkpoint at path, which is written to the output directory before it can become
+// a staging directory. If the crawl is resumed, the pager is moved behind the last page of the previous checkpoint,
+// whose unfinished downloads are returned.
+func (cc *CrawlContext) openCheckpoint(path string) (*checkpoint, error) {
+	var prev *checkpoint
+	if cc.resume {
+		var err error
+		if prev, err = loadCheckpoint(path); errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("Resume: no checkpoint found at %q", path)
+		} else if err != nil {
+			return nil, fmt.Errorf("Resume: %w", err)
+		}
+		if prev.Thread != cc.thread.String() || prev.Pager != cc.pagerName {
+			return nil, fmt.Errorf("Resume: the checkpoint belongs to the thread %q with the pager %q", prev.Thread, prev.Pager)
+		}
+	}
+	pager := cc.Pager.(resumablePager)
+	cc.checkpoint = newCheckpoint(path, cc, pager)
+	if prev != nil {
+		pager.seek(prev.Position)
+		cc.checkpoint.Page, cc.checkpoint.Position = prev.Page, prev.Position
+		log.Notice(fmt.Sprintf("Resuming after page %d with %d unfinished downloads", prev.Page, len(prev.Downloads)))
+	}
+	return prev, nil
+}
+
+// requeue dispatches the unfinished downloads of the checkpoint prev again.
+func (cc *CrawlContext) requeue(prev *checkpoint) error {
+	c, ok := cc.Crawler.(interface {
+		redispatch(*reportDownload, string) error
+	})
+	if !ok {
+		return fmt.Errorf("Resume: the crawler cannot dispatch downloads again")
+	}
+	for _, d := range prev.Downloads {
+		if err := c.redispatch(d, prev.Output); err != nil {
+			return fmt.Errorf("Resume: %w", err)
+		}
+	}
+	return nil
+}
+
+// Checkpointed returns true if the state of the crawl was saved in a checkpoint that -resume can continue from.
+func (cc *CrawlContext) Checkpointed() bool {
+	if cc.checkpoint == nil {
+		return false
+	}
+	cc.checkpoint.mu.Lock()
+	defer cc.checkpoint.mu.Unlock()
+	return cc.checkpoint.Page > 0
+}
+
+// closeCheckpoint removes the checkpoint if the crawl completed, otherwise the final state is saved.
+func (cc *CrawlContext) closeCheckpoint(completed bool) {
+	if completed {
+		cc.checkpoint.remove()
+	} else {
+		cc.checkpoint.save()
+	}
+}
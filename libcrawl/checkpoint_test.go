@@ -0,0 +1,143 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type testPager struct {
+	pos int
+}
+
+func (p *testPager) position() int {
+	return p.pos
+}
+
+func (p *testPager) seek(pos int) {
+	p.pos = pos
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	thread, _ := url.Parse("https://example.net/thread")
+	cc := &CrawlContext{output: dir, thread: thread, pagerName: PAGER_VB4}
+	path := filepath.Join(dir, CHECKPOINT_FILE)
+	pager := new(testPager)
+	cp := newCheckpoint(path, cc, pager)
+	cp.save()
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("Checkpoint was written before the first page was crawled")
+	}
+	dls := make([]*download.Download, 4)
+	for i := range dls {
+		u, _ := url.Parse(fmt.Sprintf("https://example.net/%d.jpg", i))
+		dls[i] = &download.Download{Addr: u, Page: 1}
+		if err := dls[i].SetDir(dir); err != nil {
+			t.Fatal(err)
+		}
+		dls[i].SetFile(fmt.Sprintf("%d.jpg", i))
+		cp.dispatched(dls[i])
+	}
+	cp.collected(dls[0])
+	dls[1].Err = fmt.Errorf("Copy: %w", context.Canceled)
+	cp.collected(dls[1])
+	dls[2].Err = download.HTTPError{StatusCode: 404, Status: "404 Not Found"}
+	cp.collected(dls[2])
+	pager.pos = 2
+	cp.pageCrawled(1)
+
+	got, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Thread != thread.String() || got.Pager != PAGER_VB4 || got.Page != 1 || got.Position != 2 || got.Output != dir {
+		t.Errorf("Unexpected checkpoint %+v", got)
+	}
+	//the cancelled and the running download are unfinished
+	if len(got.Downloads) != 2 || got.Downloads[0].URL != "https://example.net/1.jpg" || got.Downloads[1].File != "3.jpg" {
+		t.Errorf("Unexpected downloads %+v", got.Downloads)
+	}
+	if _, err := os.Stat(path + ".tmp"); err == nil {
+		t.Error("Temporary file was not renamed")
+	}
+	cp.remove()
+	if _, err := os.Stat(path); err == nil {
+		t.Error("Checkpoint was not removed")
+	}
+}
+
+func TestResume(t *testing.T) {
+	var mu sync.Mutex
+	requested := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.RequestURI())
+		mu.Unlock()
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer srv.Close()
+	output := t.TempDir()
+	prev := &checkpoint{
+		Thread:   srv.URL + "/file?page=1",
+		Pager:    PAGER_QUERY,
+		Page:     2,
+		Position: 3,
+		Output:   "/old/output",
+		Downloads: []*reportDownload{
+			{URL: srv.URL + "/file?page=2", Page: 2, Reason: "pager", Dir: "/old/output", File: "2 - file"},
+		},
+	}
+	path := filepath.Join(output, CHECKPOINT_FILE)
+	b, err := json.Marshal(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := NewCrawlContext(PAGER_QUERY, CRAWLER_FILE, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.SetOptions([]string{"-resume", "true", "-manifest", "false"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Pager.SetOptions([]string{"-start", "1", "-end", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.SetUrl(srv.URL + "/file?page=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Crawler.SetOptions(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Crawl(cc); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(requested)
+	if len(requested) != 2 || requested[0] != "/file?page=2" || requested[1] != "/file?page=3" {
+		t.Errorf("Unexpected requests %v", requested)
+	}
+	for _, name := range []string{"2 - file", "3 - file"} {
+		if _, err := os.Stat(filepath.Join(output, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("Checkpoint of a completed crawl was not removed")
+	}
+}
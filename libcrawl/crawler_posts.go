@@ -0,0 +1,346 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"github.com/jwdev42/bbcrawl/libhtml"
+	"github.com/jwdev42/bbcrawl/libhttp"
+	"github.com/jwdev42/bbcrawl/libhttp/redirect"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Forum engines the posts crawler understands.
+const (
+	ENGINE_AUTO      = "auto" //detect the engine from the first page that has posts
+	ENGINE_VBULLETIN = "vbulletin"
+	ENGINE_PHPBB     = "phpbb"
+	ENGINE_XENFORO   = "xenforo"
+)
+
+// postParser returns the posts of a page of a forum engine, nil if the page has none. now resolves relative dates.
+type postParser func(document *html.Node, page *url.URL, now time.Time) ([]*threadPost, error)
+
+var postParsers = map[string]postParser{
+	ENGINE_VBULLETIN: vbThreadPosts,
+	ENGINE_PHPBB:     phpbbThreadPosts,
+	ENGINE_XENFORO:   xfThreadPosts,
+}
+
+// postEngines is the order in which the engines are tried if the engine is detected.
+var postEngines = []string{ENGINE_VBULLETIN, ENGINE_XENFORO, ENGINE_PHPBB}
+
+// memberPath matches the ID in the address of a member's profile, e.g. "members/42-name" or "members/name.42/".
+var memberPath = regexp.MustCompile(`members?/(?:[^/]*\.)?([0-9]+)`)
+
+// threadPost is a post as it is written by the PostsCrawler, one JSON object per line.
+type threadPost struct {
+	ID          string   `json:"id"`
+	Author      string   `json:"author"`
+	AuthorID    string   `json:"author_id,omitempty"` //empty for guests
+	Date        string   `json:"date,omitempty"`      //as shown by the forum
+	Timestamp   string   `json:"timestamp,omitempty"` //Date in ISO 8601, with time zone if the forum states it
+	Permalink   string   `json:"permalink"`
+	Page        int      `json:"page"`
+	HTML        string   `json:"html"`
+	Text        string   `json:"text"`
+	Quotes      []string `json:"quotes"` //IDs of the quoted posts
+	Attachments []string `json:"attachments"`
+}
+
+// PostsCrawler archives the discussion of a thread. It writes every post to a JSON Lines file in the output
+// directory, posts that are already in the file are left out. Attachments are listed, but not downloaded.
+type PostsCrawler struct {
+	*baseCrawler
+	engine  string
+	file    string //name of the posts file relative to the output directory, empty for the thread's name
+	path    string
+	out     *os.File
+	enc     *json.Encoder
+	known   map[string]bool //IDs of the posts in the posts file
+	written int
+}
+
+func NewPostsCrawler(cc *CrawlContext) (CrawlerInterface, error) {
+	crawler := &PostsCrawler{
+		baseCrawler: newBaseCrawler(cc),
+		known:       make(map[string]bool),
+	}
+	return crawler, nil
+}
+
+func (r *PostsCrawler) SetOptions(args []string) error {
+	set := flag.NewFlagSet("PostsCrawler", flag.ContinueOnError)
+	common := addCommonCrawlerFlags(set)
+	engine := set.String("engine", ENGINE_AUTO, "forum software of the thread: auto, vbulletin, phpbb or xenforo")
+	file := set.String("file", "", "name of the JSON Lines file the posts are written to, default is the name of the thread")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	r.options = setOptions(set)
+	r.excluded = common.excludedURLs.URLs
+	if *common.allowRedirect {
+		r.redirect = redirect.Log
+	} else {
+		r.redirect = redirect.Deny
+	}
+	r.debug = bool(*common.debugMode)
+	if _, ok := postParsers[*engine]; !ok && *engine != ENGINE_AUTO {
+		return fmt.Errorf("Unknown forum engine %q", *engine)
+	}
+	if what := r.cc.sinkKind(); what != "" {
+		return fmt.Errorf("posts: not supported for %s", what)
+	}
+	r.engine, r.file = *engine, *file
+	return nil
+}
+
+func (r *PostsCrawler) Crawl(u *url.URL) error {
+	if r.cc.dryRun == nil {
+		if err := r.open(); err != nil {
+			return fmt.Errorf("Posts: %w", err)
+		}
+	}
+	resp, err := r.getPage(u)
+	if errors.Is(err, errNotModified) {
+		log.Info(fmt.Sprintf("Page %q has not changed since the previous crawl", u.String()))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := libhttp.BodyUTF8(resp)
+	if err != nil {
+		return err
+	}
+	document, err := html.Parse(body)
+	if err != nil {
+		return err
+	}
+	posts, err := r.parse(document, u)
+	if err != nil {
+		return err
+	}
+	page := r.cc.Pager.PageNum()
+	if r.cc.dryRun != nil {
+		r.cc.dryRun.addPosts(page, len(posts))
+	}
+	if len(posts) == 0 {
+		log.Error(fmt.Sprintf("No posts found at page %q", u.String()))
+		return nil
+	} else if r.cc.dryRun != nil {
+		return nil
+	}
+	added := 0
+	for _, post := range posts {
+		if r.known[post.ID] {
+			continue
+		}
+		post.Page = page
+		if post.Quotes == nil {
+			post.Quotes = make([]string, 0)
+		}
+		if post.Attachments == nil {
+			post.Attachments = make([]string, 0)
+		}
+		if err := r.enc.Encode(post); err != nil {
+			return fmt.Errorf("Posts: %w", err)
+		}
+		r.known[post.ID] = true
+		added++
+	}
+	r.written += added
+	log.Info(fmt.Sprintf("Page %d: %d posts, %d new", page, len(posts), added))
+	return nil
+}
+
+// parse returns the posts of document. If the engine is detected, the first engine that finds posts is used
+// for the rest of the crawl.
+func (r *PostsCrawler) parse(document *html.Node, page *url.URL) ([]*threadPost, error) {
+	if r.engine != ENGINE_AUTO {
+		return postParsers[r.engine](document, page, r.cc.started)
+	}
+	for _, engine := range postEngines {
+		posts, err := postParsers[engine](document, page, r.cc.started)
+		if err != nil {
+			return nil, err
+		}
+		if len(posts) > 0 {
+			log.Info(fmt.Sprintf("Forum engine detected: %s", engine))
+			r.engine = engine
+			return posts, nil
+		}
+	}
+	return nil, nil
+}
+
+// open opens the posts file for appending and reads the IDs of the posts it holds. A last line that was cut off
+// by an interrupted crawl is removed. As long as the file holds no posts, pages known to the manifest are requested
+// again, since they may have been crawled by another crawler.
+func (r *PostsCrawler) open() error {
+	if r.out != nil {
+		return nil
+	}
+	name := r.file
+	if name == "" {
+		name = download.SanitizeName(threadName(r.cc.thread)+".jsonl", r.cc.fsTarget)
+	}
+	path := filepath.Join(r.cc.output, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	end, err := r.readKnown(f)
+	if err == nil {
+		err = f.Truncate(end)
+	}
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.path, r.out = path, f
+	r.enc = json.NewEncoder(f)
+	r.enc.SetEscapeHTML(false)
+	//the manifest may know the pages from a crawl into another file
+	r.refetch = len(r.known) == 0
+	if len(r.known) > 0 {
+		log.Info(fmt.Sprintf("Posts file %q holds %d posts, they are not written again", path, len(r.known)))
+	}
+	return nil
+}
+
+// readKnown records the IDs of the posts in f and returns the offset behind its last complete line.
+func (r *PostsCrawler) readKnown(f *os.File) (int64, error) {
+	br := bufio.NewReader(f)
+	var end int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return end, nil
+		} else if err != nil {
+			return 0, err
+		}
+		end += int64(len(line))
+		post := new(threadPost)
+		if json.Unmarshal(line, post) == nil && post.ID != "" {
+			r.known[post.ID] = true
+		}
+	}
+}
+
+// Finish closes the posts file.
+func (r *PostsCrawler) Finish() {
+	r.baseCrawler.Finish()
+	if r.out == nil {
+		return
+	}
+	if err := r.out.Close(); err != nil {
+		log.Error(fmt.Errorf("Posts: %w", err))
+	}
+	log.Info(fmt.Sprintf("Wrote %d posts to %q", r.written, r.path))
+}
+
+/* functions that are used by the post parsers of all engines: */
+
+// memberID returns the ID of the member whose profile is at href. vBulletin and phpBB pass it as parameter "u".
+func memberID(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if id := leadingDigits(u.Query().Get("u")); id != "" {
+		return id
+	}
+	if m := memberPath.FindStringSubmatch(u.Path); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// linkedPosts returns the IDs of the posts that the links below of n point to, like the links quotes have to the
+// quoted post. The first group of fragment matches the ID in the fragment of a link, phpBB also adds the attribute
+// data-post-id.
+func linkedPosts(n *html.Node, fragment *regexp.Regexp) []string {
+	ids := make([]string, 0)
+	for _, a := range libhtml.ElementsByTag(n, atom.A) {
+		id := leadingDigits(libhtml.AttrVal(a, "data-post-id"))
+		if id == "" {
+			if u, err := url.Parse(libhtml.AttrVal(a, "href")); err == nil {
+				if m := fragment.FindStringSubmatch(u.Fragment); m != nil {
+					id = m[1]
+				}
+			}
+		}
+		ids = appendUnique(ids, id)
+	}
+	return ids
+}
+
+// attachmentLinks returns the addresses of the links and images below of n that are attachments. id returns the
+// ID of the attachment at an address or "" if it is none, every attachment is listed once.
+func attachmentLinks(n *html.Node, page *url.URL, id func(*url.URL) string) []string {
+	links := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, elem := range libhtml.ElementsByTag(n, atom.A, atom.Img) {
+		attr := "href"
+		if elem.DataAtom == atom.Img {
+			attr = "src"
+		}
+		u, err := url.Parse(libhtml.AttrVal(elem, attr))
+		if err != nil {
+			continue
+		}
+		u = page.ResolveReference(u)
+		if key := id(u); key != "" && !seen[key] {
+			seen[key] = true
+			links = append(links, u.String())
+		}
+	}
+	return links
+}
+
+// postAnchor returns the address of the anchor of a post on page.
+func postAnchor(page *url.URL, anchor string) string {
+	u := *page
+	u.Fragment = anchor
+	return u.String()
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+func appendUnique(list []string, s string) []string {
+	if s == "" {
+		return list
+	}
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,207 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"bufio"
+	"encoding/json"
+	"golang.org/x/net/html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThreadPosts(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		file   string
+		engine string
+		page   string
+		want   []threadPost
+	}{
+		{"vb4_thread.html", ENGINE_VBULLETIN, "https://forum.example.net/showthread.php?t=77", []threadPost{
+			{ID: "1001", Author: "jdoe", AuthorID: "42", Date: "01-15-2020, 10:23 AM", Timestamp: "2020-01-15T10:23:00",
+				Permalink: "https://forum.example.net/showthread.php?t=77&p=1001&viewfull=1#post1001",
+				Text:      "Here are the photos\nfrom our holiday.", Quotes: []string{},
+				Attachments: []string{
+					"https://forum.example.net/attachment.php?attachmentid=501&d=1579083780",
+					"https://forum.example.net/attachment.php?attachmentid=502&d=1579083781",
+				}},
+			{ID: "1002", Author: "Visitor", Date: "Yesterday, 08:05 PM", Timestamp: "2020-02-29T20:05:00",
+				Permalink: "https://forum.example.net/showthread.php?t=77&p=1002&viewfull=1#post1002",
+				Quotes:    []string{"1001"}},
+		}},
+		{"vb3_thread.html", ENGINE_VBULLETIN, "https://forum.example.net/showthread.php?t=9&page=2", []threadPost{
+			{ID: "2001", Author: "Mechäniker", AuthorID: "7", Date: "03-07-2009, 14:30", Timestamp: "2009-03-07T14:30:00",
+				Permalink: "https://forum.example.net/showpost.php?p=2001&postcount=11",
+				Text:      "Swapped the engine today.\nPictures below.", Quotes: []string{},
+				Attachments: []string{"https://forum.example.net/attachment.php?attachmentid=901&d=1236436200"}},
+		}},
+		{"phpbb_topic.html", ENGINE_PHPBB, "https://forum.example.net/viewtopic.php?t=77", []threadPost{
+			{ID: "120", Author: "jdoe", AuthorID: "2", Date: "Wed Jan 15, 2020 10:23 am", Timestamp: "2020-01-15T09:23:00Z",
+				Permalink: "https://forum.example.net/viewtopic.php?p=120#p120",
+				Text:      "Swapped the engine today.\nPictures below.", Quotes: []string{},
+				Attachments: []string{
+					"https://forum.example.net/download/file.php?id=5&mode=view",
+					"https://forum.example.net/download/file.php?id=6",
+				}},
+			{ID: "123", Author: "Visitor", Date: "Thu Jan 16, 2020 7:05 pm", Timestamp: "2020-01-16T18:05:00Z",
+				Permalink: "https://forum.example.net/viewtopic.php?p=123#p123",
+				Quotes:    []string{"120"}, Attachments: []string{}},
+		}},
+		{"xenforo_thread.html", ENGINE_XENFORO, "https://forum.example.net/threads/engine-swap.77/", []threadPost{
+			{ID: "55", Author: "jdoe", AuthorID: "42", Date: "Jan 15, 2020", Timestamp: "2020-01-15T10:23:00+01:00",
+				Permalink: "https://forum.example.net/threads/engine-swap.77/post-55",
+				Text:      "Swapped the engine today.\nPictures below.", Quotes: []string{},
+				Attachments: []string{"https://forum.example.net/attachments/engine-jpg.9/"}},
+			{ID: "56", Author: "mike", AuthorID: "7", Date: "Jan 16, 2020", Timestamp: "2020-01-16T19:05:00+01:00",
+				Permalink: "https://forum.example.net/threads/engine-swap.77/post-56",
+				Quotes:    []string{"55"}, Attachments: []string{}},
+		}},
+	}
+	for _, test := range tests {
+		f, err := os.Open(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		document, err := html.Parse(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, _ := url.Parse(test.page)
+		posts, err := postParsers[test.engine](document, page, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) != len(test.want) {
+			t.Fatalf("%s: expected %d posts, got %d", test.file, len(test.want), len(posts))
+		}
+		for i, got := range posts {
+			want := test.want[i]
+			if got.HTML == "" {
+				t.Errorf("%s: post %s has no html", test.file, got.ID)
+			}
+			//the texts of quoting posts are not compared
+			if want.Text == "" {
+				want.Text = got.Text
+			}
+			want.HTML = got.HTML
+			if got.Attachments == nil {
+				got.Attachments = make([]string, 0)
+			}
+			if want.Attachments == nil {
+				want.Attachments = make([]string, 0)
+			}
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("%s: expected %+v, got %+v", test.file, want, *got)
+			}
+		}
+		//other engines find no posts
+		for engine, parse := range postParsers {
+			if engine == test.engine {
+				continue
+			}
+			if posts, _ := parse(document, page, now); len(posts) > 0 {
+				t.Errorf("%s: %s found %d posts", test.file, engine, len(posts))
+			}
+		}
+	}
+}
+
+func TestMemberID(t *testing.T) {
+	tests := map[string]string{
+		"member.php?u=42-jdoe":                      "42",
+		"./memberlist.php?mode=viewprofile&u=2":     "2",
+		"https://forum.example.net/members/42-jdoe": "42",
+		"/members/j.doe.42/":                        "42",
+		"/search.php?author=jdoe":                   "",
+	}
+	for href, want := range tests {
+		if got := memberID(href); got != want {
+			t.Errorf("%s: expected %q, got %q", href, want, got)
+		}
+	}
+}
+
+func TestPostsCrawler(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "vb4_thread.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/showthread.php" {
+			t.Errorf("Unexpected request %q", r.URL.String())
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b)
+	}))
+	defer srv.Close()
+	output := t.TempDir()
+	path := filepath.Join(output, "showthread-77.jsonl")
+	crawl := func() {
+		cc, err := NewCrawlContext(PAGER_QUERY, CRAWLER_POSTS, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.SetOptions([]string{"-manifest", "false"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.Pager.SetOptions([]string{"-start", "1", "-end", "1"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.SetUrl(srv.URL + "/showthread.php?t=77&page=1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.Crawler.SetOptions(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := Crawl(cc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() []*threadPost {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		posts := make([]*threadPost, 0)
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			post := new(threadPost)
+			if err := json.Unmarshal(s.Bytes(), post); err != nil {
+				t.Fatal(err)
+			}
+			posts = append(posts, post)
+		}
+		return posts
+	}
+	crawl()
+	posts := read()
+	if len(posts) != 2 || posts[0].ID != "1001" || posts[0].Page != 1 || len(posts[0].Attachments) != 2 ||
+		!strings.Contains(posts[1].HTML, "bbcode_quote") {
+		t.Fatalf("Unexpected posts %+v", posts)
+	}
+
+	//cut the second post off as if the crawl had been interrupted while writing it
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+	crawl()
+	posts = read()
+	if len(posts) != 2 || posts[0].ID != "1001" || posts[1].ID != "1002" {
+		t.Errorf("Unexpected posts after the second crawl %+v", posts)
+	}
+}
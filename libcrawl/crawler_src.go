@@ -4,10 +4,10 @@
 package libcrawl
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/jwdev42/bbcrawl/cmdline"
-	"github.com/jwdev42/bbcrawl/libcrawl/download"
 	"github.com/jwdev42/bbcrawl/libhtml"
 	"github.com/jwdev42/bbcrawl/libhttp"
 	"github.com/jwdev42/bbcrawl/libhttp/redirect"
@@ -15,13 +15,16 @@ import (
 	"golang.org/x/net/html/atom"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 type SrcCrawler struct {
 	*baseCrawler
 	attrs  []html.Attribute
+	filter string //attrs as a CSS attribute selector
 	atoms  []atom.Atom
 	fileid int
 }
@@ -37,7 +40,10 @@ func (r *SrcCrawler) Crawl(u *url.URL) error {
 	const attr_src = "src"
 	r.fileid = 1
 	resp, err := r.getPage(u)
-	if err != nil {
+	if errors.Is(err, errNotModified) {
+		log.Info(fmt.Sprintf("Page %q has not changed since the previous crawl", u.String()))
+		return nil
+	} else if err != nil {
 		return err
 	}
 	body, err := libhttp.BodyUTF8(resp)
@@ -62,12 +68,12 @@ func (r *SrcCrawler) Crawl(u *url.URL) error {
 			if r.hasAtom(n.DataAtom) && libhtml.MatchAttrs(n, r.attrs...) {
 				link := libhtml.AttrVal(n, attr_src)
 				if len(link) > 0 {
-					name, err := r.uniqueName(link)
+					name, err := r.fileName(link)
 					if err != nil {
 						log.Error(fmt.Errorf("Download error: %v", err))
 						break
 					}
-					if err := r.download(u, link, r.cc.output, name); err != nil {
+					if err := r.download(u, link, r.cc.output, name, n.Data+r.filter+"[src]"); err != nil {
 						log.Error(fmt.Errorf("Download error: %v", err))
 					}
 				}
@@ -95,6 +101,7 @@ func (r *SrcCrawler) SetOptions(args []string) error {
 	if err := set.Parse(args); err != nil {
 		return err
 	}
+	r.options = setOptions(set)
 	r.excluded = common.excludedURLs.URLs
 	if *common.allowRedirect {
 		r.redirect = redirect.Log
@@ -103,6 +110,7 @@ func (r *SrcCrawler) SetOptions(args []string) error {
 	}
 	r.debug = bool(*common.debugMode)
 	r.attrs = cmdAttrs2htmlAttrs(cmdattrs)
+	r.filter = attrSelector(r.attrs)
 	if len(taglist.Result()) == 0 {
 		return fmt.Errorf("No html tag specified with \"-tags\"")
 	}
@@ -110,7 +118,8 @@ func (r *SrcCrawler) SetOptions(args []string) error {
 	return nil
 }
 
-func (r *SrcCrawler) download(page *url.URL, link, dir, name string) error {
+// download dispatches the download of link, reason is the selector of the element link was found in.
+func (r *SrcCrawler) download(page *url.URL, link, dir, name, reason string) error {
 	if link == "" {
 		panic("link must not be empty")
 	}
@@ -124,15 +133,13 @@ func (r *SrcCrawler) download(page *url.URL, link, dir, name string) error {
 			return err
 		}
 	}
-	dl := &download.Download{
-		Client: r.client,
-		Addr:   u,
-	}
+	dl := r.newDownload(u)
+	dl.Reason = reason
 	if err := dl.SetDir(dir); err != nil {
 		return err
 	}
-	dl.SetFile(name)
-	r.dispatcher.Dispatch(dl)
+	dl.SetPath(name)
+	r.dispatch(dl)
 	return nil
 }
 
@@ -140,34 +147,56 @@ func (r *SrcCrawler) download(page *url.URL, link, dir, name string) error {
 func (r *SrcCrawler) scrapeAV(page *url.URL, node *html.Node) error {
 	const attr_src = "src"
 	downloads := make([]string, 0, 5)
+	selector := node.Data + r.filter
+	reasons := make(map[string]string) //selector by link
 	root := libhtml.AttrVal(node, attr_src)
 	if len(root) > 0 {
 		downloads = append(downloads, root)
+		reasons[root] = selector + "[src]"
 	}
 	children := libhtml.ElementsByTag(node, atom.Source, atom.Track)
 	for _, child := range children {
 		link := libhtml.AttrVal(child, attr_src)
 		if len(link) > 0 {
 			downloads = append(downloads, link)
+			if _, ok := reasons[link]; !ok {
+				reasons[link] = selector + " " + child.Data + "[src]"
+			}
 		}
 	}
 	switch len(downloads) {
 	case 0:
 		return nil
 	case 1:
-		name, err := r.uniqueName(downloads[0])
+		name, err := r.fileName(downloads[0])
 		if err != nil {
 			log.Error(fmt.Errorf("Download error: %v", err))
 			break
 		}
-		if err := r.download(page, downloads[0], r.cc.output, name); err != nil {
+		if err := r.download(page, downloads[0], r.cc.output, name, reasons[downloads[0]]); err != nil {
 			log.Error(fmt.Errorf("Download error: %v", err))
 		}
 	default:
+		//a name template decides about subdirectories on its own
+		if r.cc.nameTemplate != nil {
+			for _, link := range downloads {
+				name, err := r.fileName(link)
+				if err != nil {
+					log.Error(fmt.Errorf("Download error: %v", err))
+					continue
+				}
+				if err := r.download(page, link, r.cc.output, name, reasons[link]); err != nil {
+					log.Error(fmt.Errorf("Download error: %v", err))
+				}
+			}
+			break
+		}
 		dir := filepath.Join(r.cc.output, fmt.Sprintf("%d-%d", r.cc.Pager.PageNum(), r.fileid))
 		r.fileid++
-		if err := os.Mkdir(dir, 0755); err != nil {
-			return err
+		if r.cc.dryRun == nil {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				return err
+			}
 		}
 
 		sources := make(avTag)
@@ -177,7 +206,7 @@ func (r *SrcCrawler) scrapeAV(page *url.URL, node *html.Node) error {
 			}
 		}
 		for link, name := range sources {
-			if err := r.download(page, link, dir, name); err != nil {
+			if err := r.download(page, link, dir, name, reasons[link]); err != nil {
 				log.Error(fmt.Errorf("Download error: %v", err))
 			}
 		}
@@ -185,36 +214,47 @@ func (r *SrcCrawler) scrapeAV(page *url.URL, node *html.Node) error {
 	return nil
 }
 
-// uniqueName constructs a unique file name by extracting the input url's file extension and combining it with a unique string
-func (r *SrcCrawler) uniqueName(s string) (string, error) {
-	var suffix string
+// fileName returns the path of a download relative to the output directory. It uses the name template if there is one,
+// otherwise it falls back to uniqueName.
+func (r *SrcCrawler) fileName(s string) (string, error) {
+	if r.cc.nameTemplate == nil {
+		return r.uniqueName(s)
+	}
 	u, err := url.Parse(s)
 	if err != nil {
 		return "", err
 	}
-	li := strings.LastIndex(u.Path, ".")
-	if li+1 < len(u.Path) {
-		suffix = u.Path[li+1:]
-	} else {
-		return "", fmt.Errorf("No suffix available in url path \"%s\"", u.Path)
+	fid := r.fileid
+	r.fileid++
+	return r.cc.nameTemplate.expand(r.nameVars(u, "", fid)), nil
+}
+
+// uniqueName constructs a unique file name by extracting the input url's file extension and combining it with a unique string.
+// The name has no extension if the url path has none, the downloader corrects it once the content type is known.
+func (r *SrcCrawler) uniqueName(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
 	}
+	suffix := path.Ext(u.Path)
 	fid := r.fileid
 	r.fileid++
-	return fmt.Sprintf("%d-%d.%s", r.cc.Pager.PageNum(), fid, suffix), nil
+	return fmt.Sprintf("%d-%d%s", r.cc.Pager.PageNum(), fid, suffix), nil
 }
 
-func (r *SrcCrawler) hasAtom(atom atom.Atom) bool {
-	for _, a := range r.atoms {
-		if a == atom {
-			return true
-		}
+// attrSelector formats attrs as CSS attribute selectors in a stable order, e.g. [alt="a"][class="thumb"].
+func attrSelector(attrs []html.Attribute) string {
+	selectors := make([]string, len(attrs))
+	for i, a := range attrs {
+		selectors[i] = fmt.Sprintf("[%s=%q]", a.Key, a.Val)
 	}
-	return false
+	sort.Strings(selectors)
+	return strings.Join(selectors, "")
 }
 
-func (r *SrcCrawler) isExcluded(url *url.URL) bool {
-	for _, exurl := range r.excluded {
-		if exurl.String() == url.String() {
+func (r *SrcCrawler) hasAtom(atom atom.Atom) bool {
+	for _, a := range r.atoms {
+		if a == atom {
 			return true
 		}
 	}
@@ -4,10 +4,15 @@
 package libcrawl
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/jwdev42/bbcrawl/cmdline"
 	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"github.com/jwdev42/bbcrawl/libcrawl/manifest"
 	"github.com/jwdev42/bbcrawl/libhtml"
 	"github.com/jwdev42/bbcrawl/libhttp"
 	"github.com/jwdev42/bbcrawl/libhttp/redirect"
@@ -21,12 +26,14 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 const (
 	CRAWLER_VB_ATTACHMENTS = "vb-attachments"
 	CRAWLER_SRC            = "src"
 	CRAWLER_FILE           = "file"
+	CRAWLER_POSTS          = "posts"
 )
 
 var vb4_regex_postid *regexp.Regexp = regexp.MustCompile("^post_?[0-9]+$")
@@ -42,14 +49,23 @@ type baseCrawler struct {
 	yield         chan int
 	excluded      []*url.URL
 	redirect      func(*http.Request, []*http.Request) error
+	pages         *pageTracker
+	pageAddr      *url.URL //address of the page that was requested last
+	refetch       bool     //request pages unconditionally even if the manifest knows them
+	options       []string //crawler options as "name=value", they decide which downloads a page yields
 }
 
+// errNotModified is returned by getPage if the page did not change since the previous crawl.
+var errNotModified = errors.New("page not modified")
+
 func newBaseCrawler(cc *CrawlContext) *baseCrawler {
-	return &baseCrawler{
+	c := &baseCrawler{
 		cc: cc, client: new(http.Client),
 		excluded: make([]*url.URL, 0, 1),
 		redirect: redirect.Log,
 	}
+	c.pages = newPageTracker(c.pageComplete)
+	return c
 }
 
 func (c *baseCrawler) debug_DumpHeader(dir, name string, header http.Header) {
@@ -85,8 +101,13 @@ func (c *baseCrawler) debug_DumpHeader(dir, name string, header http.Header) {
 // At first the http client's CheckRedirect function is set to baseCrawler's "redirect" member. Secondly a new cookie jar
 // is deployed to the http client if there isn't already one. Thirdly the cookie jar is filled with the CrawlContext's cookie slice,
 // but only if the cookie jar did not exist before (i.e. on the first call).
+// If the manifest knows the page, the request is conditional and errNotModified is returned if the page did not
+// change since the previous crawl.
 func (c *baseCrawler) getPage(page *url.URL) (*http.Response, error) {
 	c.client.CheckRedirect = c.redirect
+	if err := c.cc.limiter.Wait(context.Background(), page); err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest("GET", page.String(), nil)
 	if err != nil {
 		return nil, err
@@ -113,14 +134,33 @@ func (c *baseCrawler) getPage(page *url.URL) (*http.Response, error) {
 	for _, cookie := range cookies {
 		req.AddCookie(cookie)
 	}
+	if m := c.cc.manifest; m != nil && !c.cc.refresh && !c.refetch && c.cc.dryRun == nil {
+		if e := m.LookupPage(page.String(), c.pageFilter()); e != nil {
+			if e.ETag != "" {
+				req.Header.Set("If-None-Match", e.ETag)
+			}
+			if e.LastModified != "" {
+				req.Header.Set("If-Modified-Since", e.LastModified)
+			}
+		}
+	}
 	if c.debug {
 		c.debug_DumpHeader(filepath.Join(c.cc.output, "debug"), "Request Header", req.Header)
 	}
 	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
 	if c.debug {
 		c.debug_DumpHeader(filepath.Join(c.cc.output, "debug"), "Response Header", resp.Header)
 	}
-	return resp, err
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, errNotModified
+	}
+	c.pageAddr = page
+	c.pages.fetched(c.cc.Pager.PageNum(), page, resp.Header)
+	return resp, nil
 }
 
 // redirection sets the optional redirection handler function for the crawler's http.Client
@@ -130,30 +170,232 @@ func (c *baseCrawler) redirection(redirect func(*http.Request, []*http.Request)
 
 func (c *baseCrawler) setup(jobs int) {
 	c.dispatcher = download.NewDownloadDispatcher(jobs)
+	c.dispatcher.SetLimiter(c.cc.limiter)
+	c.dispatcher.SetMaxRate(c.cc.maxRate)
+	c.dispatcher.SetSizeLimits(c.cc.minSize, c.cc.maxSize)
+	c.dispatcher.SetAccept(c.cc.accept)
+	c.dispatcher.SetImageFilter(c.cc.imageFilter)
+	c.dispatcher.SetFixExtensions(c.cc.fixExt)
+	c.dispatcher.SetMetadata(c.cc.metadata)
+	c.dispatcher.SetDedupe(c.cc.dedupe)
+	if c.cc.sink != nil {
+		c.dispatcher.SetSink(c.cc.sink)
+	}
+	if c.cc.exec != nil {
+		c.dispatcher.SetHook(c.cc.exec.afterDownload)
+	}
+	if c.cc.progress != nil {
+		c.dispatcher.SetProgress(c.cc.progress)
+	}
+	if m := c.cc.manifest; m != nil && c.cc.dedupe != download.DedupeOff {
+		//files of previous crawls count as first copies
+		for _, e := range m.Files() {
+			sum, err := hex.DecodeString(e.SHA256)
+			if err == nil && len(sum) > 0 && m.Exists(e) {
+				c.dispatcher.AddKnownFile(sum, filepath.Join(c.cc.output, filepath.FromSlash(e.File)))
+			}
+		}
+	}
 	c.yield = make(chan int)
 	f := func() {
 		for dl := c.dispatcher.Collect(); dl != nil; dl = c.dispatcher.Collect() {
+			if err, ok := dl.Err.(download.MetadataError); ok {
+				//the file itself is complete
+				log.Error(fmt.Errorf("%s: %s", err, err.Unwrap()))
+				dl.Err = nil
+			}
 			if dl.Err != nil {
+				if errors.Is(dl.Err, context.Canceled) {
+					c.cc.noteCancelled(dl.Page)
+				}
 				if err, ok := dl.Err.(download.RenameError); ok {
 					log.Error(fmt.Errorf("%s: %s", err, err.Unwrap()))
 				} else {
 					log.Error(fmt.Errorf("Download failed %q: %w", dl.Addr.String(), dl.Err))
 				}
+			} else if dl.Skipped == download.SkipDuplicate {
+				log.Notice(fmt.Sprintf("Download skipped (%s %q): %s", dl.Skipped, dl.Duplicate(), dl.Addr.String()))
+				c.record(dl)
+			} else if dl.Skipped != download.SkipNone {
+				log.Notice(fmt.Sprintf("Download skipped (%s): %s", dl.Skipped, dl.Addr.String()))
+			} else if dl.Duplicate() != "" {
+				log.Notice(fmt.Sprintf("Download complete: %s → %s, duplicate of %q", dl.Addr.String(), dl.File(), dl.Duplicate()))
+				c.record(dl)
 			} else {
 				log.Info(fmt.Sprintf("Download complete: %s → %s", dl.Addr.String(), dl.File()))
+				c.record(dl)
+			}
+			if c.cc.report != nil {
+				c.cc.report.add(dl)
 			}
+			if cp := c.cc.checkpoint; cp != nil {
+				cp.collected(dl)
+			}
+			c.pages.collected(dl.Page, dl.Err != nil)
 		}
 		c.yield <- 1
 	}
 	go f()
 }
 
+// newDownload returns a Download for address u that uses the crawler's http client and is attributed to the current page.
+func (c *baseCrawler) newDownload(u *url.URL) *download.Download {
+	dl := &download.Download{
+		Client:     c.client,
+		Addr:       u,
+		Page:       c.cc.Pager.PageNum(),
+		PageAddr:   c.pageAddr,
+		Target:     c.cc.fsTarget,
+		OnConflict: c.cc.onConflict,
+	}
+	if c.cc.sink != nil {
+		//archive and S3 sinks apply the conflict policy themselves, the staging directory must not get in the way
+		dl.OnConflict = download.ConflictRename
+	}
+	return dl
+}
+
+// dispatch passes dl to the crawler's DownloadDispatcher. Excluded downloads and downloads that are known to the
+// manifest and still exist are marked as skipped, the latter unless a refresh was requested. If revalidation was
+// requested, they are downloaded conditionally instead. Downloads whose file was removed on purpose are skipped as
+// well. A dry run only lists dl.
+func (c *baseCrawler) dispatch(dl *download.Download) {
+	if c.isExcluded(dl.Addr) {
+		dl.Skipped = download.SkipExcluded
+	}
+	if m := c.cc.manifest; m != nil && !c.cc.refresh && dl.Skipped == download.SkipNone {
+		if e := m.Lookup(dl.Addr.String()); e != nil && e.Kind == manifest.KindRemoved {
+			dl.Skipped = download.SkipDownloaded
+		} else if e != nil && m.Exists(e) {
+			if c.cc.revalidate && (e.ETag != "" || e.LastModified != "") {
+				dl.Revalidate(filepath.Join(c.cc.output, filepath.FromSlash(e.File)), e.ETag, e.LastModified)
+			} else {
+				dl.Skipped = download.SkipDownloaded
+			}
+		}
+	}
+	if c.cc.dryRun != nil {
+		c.cc.dryRun.add(dl)
+		return
+	}
+	if s := c.cc.similar; s != nil {
+		dl.AfterDownload = download.ChainAfterDownload(dl.AfterDownload, s.afterDownload)
+	}
+	if cp := c.cc.checkpoint; cp != nil {
+		cp.dispatched(dl)
+	}
+	c.pages.dispatched(dl.Page)
+	c.dispatcher.Dispatch(dl)
+}
+
+// pageCrawled is called after the crawler is done with page.
+func (c *baseCrawler) pageCrawled(page int) {
+	if c.cc.dryRun != nil {
+		c.cc.dryRun.pageDone(page, c.pageAddr)
+		return
+	}
+	if c.cc.report != nil {
+		c.cc.report.pageCrawled(page, c.pageAddr)
+	}
+	if cp := c.cc.checkpoint; cp != nil {
+		cp.pageCrawled(page)
+	}
+	c.pages.crawled(page)
+}
+
+// pageFilter returns the fingerprint of the crawler and of all options that decide which downloads of a page are
+// made. Pages are only requested conditionally if the manifest knows them with the same fingerprint.
+func (c *baseCrawler) pageFilter() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%q\n%q\n%d\n%d\n%+v", c.cc.crawlerName, c.options, c.cc.accept, c.cc.minSize, c.cc.maxSize,
+		c.cc.imageFilter)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (c *baseCrawler) isExcluded(u *url.URL) bool {
+	for _, exurl := range c.excluded {
+		if exurl.String() == u.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// pageComplete records the validators of a page in the manifest once all of its downloads were collected.
+// Pages with failed downloads are not recorded, so that the next crawl does not skip them. The checkpoint is saved.
+func (c *baseCrawler) pageComplete(page int, st *pageState) {
+	if cp := c.cc.checkpoint; cp != nil {
+		cp.save()
+	}
+	m := c.cc.manifest
+	if m == nil || st.addr == nil || st.failed > 0 || (st.etag == "" && st.lastModified == "") {
+		return
+	}
+	e := &manifest.Entry{
+		Kind:         manifest.KindPage,
+		URL:          st.addr.String(),
+		ETag:         st.etag,
+		LastModified: st.lastModified,
+		Filter:       c.pageFilter(),
+		Page:         page,
+		Time:         time.Now(),
+	}
+	if err := m.Add(e); err != nil {
+		log.Error(fmt.Errorf("Manifest: %w", err))
+	}
+}
+
+// record adds a completed download to the manifest. Dropped duplicates are recorded with the file of their first copy.
+func (c *baseCrawler) record(dl *download.Download) {
+	m := c.cc.manifest
+	if m == nil {
+		return
+	}
+	p := dl.Path()
+	if dl.Skipped == download.SkipDuplicate {
+		//the manifest points to the first copy, so that later crawls skip the url
+		p = dl.Duplicate()
+	}
+	file, err := filepath.Rel(c.cc.output, p)
+	if err != nil {
+		log.Error(fmt.Errorf("Manifest: %w", err))
+		return
+	}
+	e := &manifest.Entry{
+		URL:          dl.Addr.String(),
+		File:         filepath.ToSlash(file),
+		Size:         dl.Size(),
+		SHA256:       hex.EncodeToString(dl.SHA256()),
+		ETag:         dl.Header().Get("ETag"),
+		LastModified: dl.Header().Get("Last-Modified"),
+		Page:         dl.Page,
+		Time:         time.Now(),
+	}
+	if err := m.Add(e); err != nil {
+		log.Error(fmt.Errorf("Manifest: %w", err))
+	}
+}
+
+// nameVars returns the name template variables for a download of u, index counts the downloads of a page or a post.
+func (c *baseCrawler) nameVars(u *url.URL, post string, index int) *nameVars {
+	return &nameVars{
+		page:   c.cc.Pager.PageNum(),
+		post:   post,
+		index:  index,
+		file:   download.FileNameFromURL(u),
+		url:    u,
+		thread: threadName(c.cc.thread),
+		date:   c.cc.started,
+	}
+}
+
 func (c *baseCrawler) SetOptions(args []string) error {
 	set := flag.NewFlagSet("baseCrawler", flag.ContinueOnError)
 	common := addCommonCrawlerFlags(set)
 	if err := set.Parse(args); err != nil {
 		return err
 	}
+	c.options = setOptions(set)
 	c.excluded = common.excludedURLs.URLs
 	if *common.allowRedirect {
 		c.redirect = redirect.Log
@@ -168,6 +410,13 @@ func (c *baseCrawler) Setup() {
 	c.setup(DEFAULT_DL_JOBS)
 }
 
+// Abort cancels all downloads that are still running.
+func (c *baseCrawler) Abort() {
+	if c.dispatcher != nil {
+		c.dispatcher.Abort()
+	}
+}
+
 // Finish() is a default cleanup function for crawlers, If baseCrawler's Setup() or setup() method was used
 // Finish() closes baseCrawler's DownloadDispatcher and yields until all Downloads have been finished.
 // Otherwise it does nothing.
@@ -175,6 +424,11 @@ func (c *baseCrawler) Finish() {
 	if c.yield != nil {
 		c.dispatcher.Close()
 		<-c.yield
+		if s := c.cc.similar; s != nil && c.cc.dryRun == nil {
+			if err := s.finish(c.cc.output, c.cc.manifest); err != nil {
+				log.Error(fmt.Errorf("Similar images: %w", err))
+			}
+		}
 	}
 }
 
@@ -191,7 +445,7 @@ func NewFileCrawler(cc *CrawlContext) (CrawlerInterface, error) {
 func (r *FileCrawler) Crawl(u *url.URL) error {
 	var filename string
 	page := r.cc.Pager.PageNum()
-	name := fileNameFromURL(u)
+	name := download.FileNameFromURL(u)
 
 	//determine filename for download
 	if len(name) > 0 {
@@ -199,21 +453,26 @@ func (r *FileCrawler) Crawl(u *url.URL) error {
 	}
 
 	//setup Download struct
-	dl := &download.Download{Client: r.client, Addr: u}
+	dl := r.newDownload(u)
+	dl.Reason = "pager"
 	if err := dl.SetDir(r.cc.output); err != nil {
 		return err
 	}
-	if len(filename) > 0 {
+	if tmpl := r.cc.nameTemplate; tmpl != nil {
+		//every page is a single download
+		dl.SetPath(tmpl.expand(r.nameVars(u, "", 1)))
+	} else if len(filename) > 0 {
 		dl.SetFile(filename)
 	}
 	//run download
-	r.dispatcher.Dispatch(dl)
+	r.dispatch(dl)
 	return nil
 }
 
 type VBAttachmentCrawler struct {
 	*baseCrawler
 	headernames bool
+	postDirs    bool
 	page        *url.URL
 }
 
@@ -229,10 +488,16 @@ func (r *VBAttachmentCrawler) SetOptions(args []string) error {
 	set := flag.NewFlagSet("VBAttachmentCrawler", flag.ContinueOnError)
 	headernames := new(cmdline.Boolean)
 	common := addCommonCrawlerFlags(set)
+	postDirs := new(cmdline.Boolean)
 	set.Var(headernames, "names-from-header", "if true, the downloader will use the file names sent via the http header")
+	set.Var(postDirs, "post-dirs", "if true, the attachments of every post are saved to their own directory together with the post's metadata")
 	if err := set.Parse(args); err != nil {
 		return err
 	}
+	if what := r.cc.sinkKind(); *postDirs && what != "" {
+		return fmt.Errorf("post-dirs: not supported for %s", what)
+	}
+	r.options = setOptions(set)
 	r.excluded = common.excludedURLs.URLs
 	if *common.allowRedirect {
 		r.redirect = redirect.Log
@@ -241,13 +506,17 @@ func (r *VBAttachmentCrawler) SetOptions(args []string) error {
 	}
 	r.debug = bool(*common.debugMode)
 	r.headernames = bool(*headernames)
+	r.postDirs = bool(*postDirs)
 	return nil
 }
 
 func (r *VBAttachmentCrawler) Crawl(u *url.URL) error {
 	r.page = u
 	resp, err := r.getPage(u)
-	if err != nil {
+	if errors.Is(err, errNotModified) {
+		log.Info(fmt.Sprintf("Page %q has not changed since the previous crawl", u.String()))
+		return nil
+	} else if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
@@ -259,12 +528,20 @@ func (r *VBAttachmentCrawler) Crawl(u *url.URL) error {
 	if err != nil {
 		return err
 	}
-	posts := r.vb4PostList(document)
+	posts := vb4PostList(document)
 	if posts == nil {
 		log.Error(fmt.Sprintf("No posts found at page %q", u.String()))
 	}
 	for _, post := range posts {
 		atts := post.attachments()
+		postid := post.id()
+		//with post directories, the attachments are saved as "postid/attid-name" next to the post's metadata
+		var dir string
+		var info *vbPostInfo
+		if r.postDirs && len(atts) > 0 {
+			dir = postid + "/"
+			info = post.info(u, r.cc.Pager.PageNum(), r.cc.started)
+		}
 		attid := 1
 		on_failure := func(u *url.URL) {
 			printFetchError(u)
@@ -283,7 +560,12 @@ func (r *VBAttachmentCrawler) Crawl(u *url.URL) error {
 					continue
 				}
 			}
-			dl := &download.Download{Client: r.client, Addr: attUrl}
+			if info != nil {
+				info.Attachments = append(info.Attachments, attUrl.String())
+			}
+			dl := r.newDownload(attUrl)
+			dl.PostID = postid
+			dl.Reason = fmt.Sprintf("post %s, #%s", dl.PostID, att.id())
 
 			//set download directory
 			if err := dl.SetDir(r.cc.output); err != nil {
@@ -291,27 +573,47 @@ func (r *VBAttachmentCrawler) Crawl(u *url.URL) error {
 				continue
 			}
 			//determine download filename
-			postid := post.id()
-			if r.headernames {
-				dl.AfterDownload = download.ADNameFromHeader(fmt.Sprintf("%s-%d", postid, attid))
+			prefix := fmt.Sprintf("%s-%d", postid, attid)
+			if info != nil {
+				prefix = fmt.Sprintf("%s%d", dir, attid)
+			}
+			tmpl := r.cc.nameTemplate
+			if r.headernames && tmpl != nil {
+				vars := r.nameVars(attUrl, postid, attid)
+				dl.NameTemplate = escapeTemplate(dir) + tmpl.partial(vars)
+				dl.AfterDownload = download.ADNameFromHeaderFunc(func(name string) string {
+					vars.file = name
+					return dir + tmpl.expand(vars)
+				})
+			} else if r.headernames {
+				dl.NameTemplate = escapeTemplate(prefix) + "-{name}.{ext}"
+				dl.AfterDownload = download.ADNameFromHeader(prefix)
+			} else if tmpl != nil {
+				dl.SetPath(dir + tmpl.expand(r.nameVars(attUrl, postid, attid)))
 			} else {
-				name := fileNameFromURL(attUrl)
+				name := download.FileNameFromURL(attUrl)
 				if name == "" {
 					on_failure(attUrl)
 					continue
 				}
-				dl.SetFile(fmt.Sprintf("%s-%d-%s", postid, attid, name))
+				dl.SetPath(prefix + "-" + name)
 			}
 
 			//run download
-			r.dispatcher.Dispatch(dl)
+			r.dispatch(dl)
 			attid++
 		}
+		if info != nil && r.cc.dryRun == nil {
+			if err := writePostInfo(filepath.Join(r.cc.output, postid), info); err != nil {
+				log.Error(fmt.Errorf("Post %s: %w", postid, err))
+			}
+		}
 	}
 	return nil
 }
 
-func (r *VBAttachmentCrawler) vb4PostList(node *html.Node) []*vbpost {
+// vb4PostList returns the posts of a page of vBulletin 3 or 4.
+func vb4PostList(node *html.Node) []*vbpost {
 	const searchForID string = "posts"
 	posts := libhtml.ElementByID(node, searchForID)
 	if posts == nil {
@@ -325,7 +627,7 @@ func (r *VBAttachmentCrawler) vb4PostList(node *html.Node) []*vbpost {
 	for i := range nodes {
 		vbposts[i] = (*vbpost)(nodes[i])
 		if log.Level() == logger.LevelDebug {
-			log.Debug(fmt.Sprintf("VBAttachmentCrawler: found post %q", vbposts[i].id()))
+			log.Debug(fmt.Sprintf("vBulletin: found post %q", vbposts[i].id()))
 		}
 	}
 	return vbposts
@@ -347,19 +649,16 @@ func (r *vbpost) attachments() []*vbattachment {
 	for i := range nodes {
 		vb4att[i] = (*vbattachment)(nodes[i])
 		if log.Level() == logger.LevelDebug {
-			var id string
-			for _, attr := range nodes[i].Attr {
-				if attr.Key == "id" {
-					id = attr.Val
-					break
-				}
-			}
-			log.Debug(fmt.Sprintf("VBAttachmentCrawler: Found attachment %q", id))
+			log.Debug(fmt.Sprintf("VBAttachmentCrawler: Found attachment %q", vb4att[i].id()))
 		}
 	}
 	return vb4att
 }
 
+func (r *vbattachment) id() string {
+	return libhtml.AttrVal((*html.Node)(r), "id")
+}
+
 func (r *vbattachment) href() (*url.URL, error) {
 	for _, a := range r.Attr {
 		if a.Key == "href" {
@@ -390,6 +689,17 @@ func addCommonCrawlerFlags(set *flag.FlagSet) *commonCrawlerFlags {
 	return &res
 }
 
+// setOptions returns the options of set that were given, except of -debug, as "name=value" in lexical order.
+func setOptions(set *flag.FlagSet) []string {
+	options := make([]string, 0, set.NFlag())
+	set.Visit(func(f *flag.Flag) {
+		if f.Name != "debug" {
+			options = append(options, f.Name+"="+f.Value.String())
+		}
+	})
+	return options
+}
+
 func cmdAttrs2htmlAttrs(attrs_cmd cmdline.Attrs) []html.Attribute {
 	attrs_html := make([]html.Attribute, 0, 10)
 	for key, vals := range attrs_cmd {
@@ -0,0 +1,97 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/manifest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPageFilter(t *testing.T) {
+	conditional := false
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/thread" {
+			w.Write([]byte("image"))
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional = true
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		//rel2absURL drops the port of the page, so the sources are absolute
+		fmt.Fprintf(w, `<html><body><img src="%s/a.jpg" class="photo"><img src="%[1]s/b.jpg"></body></html>`, srv.URL)
+	}))
+	defer srv.Close()
+	output := t.TempDir()
+	crawl := func(crawler string, options ...string) {
+		cc, err := NewCrawlContext(PAGER_QUERY, crawler, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.SetOptions(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.Pager.SetOptions([]string{"-start", "1", "-end", "1"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.SetUrl(srv.URL + "/thread?page=1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.Crawler.SetOptions(options); err != nil {
+			t.Fatal(err)
+		}
+		conditional = false
+		if err := Crawl(cc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		crawler     string
+		options     []string
+		conditional bool
+	}{
+		{CRAWLER_SRC, []string{"-tags", "img", "-attrs", "class=photo"}, false},
+		{CRAWLER_SRC, []string{"-tags", "img", "-attrs", "class=photo"}, true},
+		//the second image was skipped by the previous crawls
+		{CRAWLER_SRC, []string{"-tags", "img"}, false},
+		{CRAWLER_SRC, []string{"-tags", "img", "-debug", "false"}, true},
+		{CRAWLER_POSTS, nil, false},
+		//the posts crawler records its pages apart from the other crawlers
+		{CRAWLER_SRC, []string{"-tags", "img"}, true},
+	}
+	for i, test := range tests {
+		crawl(test.crawler, test.options...)
+		if conditional != test.conditional {
+			t.Errorf("Crawl %d with %s %v: expected a conditional request to be %t", i+1, test.crawler, test.options, test.conditional)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(output, manifest.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		e := new(manifest.Entry)
+		if err := json.Unmarshal([]byte(line), e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Kind == manifest.KindPage {
+			filters[e.Filter] = true
+		}
+	}
+	if len(filters) != 3 {
+		t.Errorf("Expected pages with 3 fingerprints in the manifest, got %v", filters)
+	}
+}
@@ -24,19 +24,30 @@ func (e RenameError) Unwrap() error {
 	return e.err
 }
 
+// ADNameFromHeader returns an AfterDownload function that renames the download to the name sent by the server,
+// prefixed by prefix. If the server did not send a name, the name is taken from the download's url, see NameFromResponse.
 func ADNameFromHeader(prefix string) func(*Download) {
+	return ADNameFromHeaderFunc(func(name string) string {
+		if len(prefix) > 0 {
+			return fmt.Sprintf("%s-%s", prefix, name)
+		}
+		return name
+	})
+}
+
+// ADNameFromHeaderFunc works like ADNameFromHeader, but the download is renamed to the path returned by rename.
+// The function receives the name determined by NameFromResponse, a "/" in its result creates a subdirectory.
+func ADNameFromHeaderFunc(rename func(name string) string) func(*Download) {
 	f := func(dl *Download) {
-		var newname string
-		name, err := dl.NameFromHeader()
+		name, err := dl.NameFromResponse()
 		if err != nil {
 			dl.Err = NewRenameError(dl.File(), name, err)
 			return
 		}
-		if len(prefix) > 0 {
-			newname = fmt.Sprintf("%s-%s", prefix, name)
-		} else {
-			newname = name
+		if dl.fixExt {
+			name = fixExtension(name, dl.contentType)
 		}
+		newname := rename(name)
 		if err := dl.Rename(newname); err != nil {
 			dl.Err = NewRenameError(dl.File(), newname, err)
 			return
@@ -44,3 +55,18 @@ func ADNameFromHeader(prefix string) func(*Download) {
 	}
 	return f
 }
+
+// ChainAfterDownload returns an AfterDownload function that calls every non-nil function of fns in order.
+// The chain stops as soon as a function lets the download fail or skips it.
+func ChainAfterDownload(fns ...func(*Download)) func(*Download) {
+	return func(dl *Download) {
+		for _, f := range fns {
+			if dl.Err != nil || dl.Skipped != SkipNone {
+				return
+			}
+			if f != nil {
+				f(dl)
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ArchiveFormat is the file format of an ArchiveSink.
+type ArchiveFormat int
+
+const (
+	ArchiveZip   ArchiveFormat = iota //zip file, entries are compressed
+	ArchiveCBZ                        //comic book zip file, entries are stored in the order they were found
+	ArchiveTar                        //uncompressed tar file
+	ArchiveTarGz                      //gzip compressed tar file
+)
+
+// ParseArchiveFormat detects the archive format by the extension of path: ".zip", ".cbz", ".tar", ".tar.gz" or ".tgz".
+// The second return value is false if path is no archive.
+func ParseArchiveFormat(path string) (ArchiveFormat, bool) {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveZip, true
+	case strings.HasSuffix(lower, ".cbz"):
+		return ArchiveCBZ, true
+	case strings.HasSuffix(lower, ".tar"):
+		return ArchiveTar, true
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return ArchiveTarGz, true
+	}
+	return ArchiveZip, false
+}
+
+func (f ArchiveFormat) String() string {
+	switch f {
+	case ArchiveZip:
+		return "zip"
+	case ArchiveCBZ:
+		return "cbz"
+	case ArchiveTar:
+		return "tar"
+	case ArchiveTarGz:
+		return "tar.gz"
+	}
+	return "unknown"
+}
+
+// ArchiveSink writes downloads into a single archive file. Every download is written to a staging directory first,
+// so that it can be post-processed like a regular file. Store appends it to the archive and removes the staged file,
+// a sidecar file is added as well. Entries are added one at a time, so concurrent downloads are serialised.
+//
+// The conflict policy of the sink applies to entry names, ConflictOverwrite behaves like ConflictRename because
+// entries cannot be replaced. Downloads that are placed in the staging directory should use ConflictRename.
+//
+// If writing an entry fails, the archive is broken: every later Store fails and Close returns the error as well.
+type ArchiveSink struct {
+	mu      sync.Mutex
+	format  ArchiveFormat
+	policy  ConflictPolicy
+	staging *staging
+	f       *os.File
+	zw      *zip.Writer
+	tw      *tar.Writer
+	gz      *gzip.Writer
+	entries map[string][]byte //SHA-256 of every entry by its name
+	err     error             //error that broke the archive, nil if it is intact
+}
+
+// NewArchiveSink creates the archive file p in the given format. Downloads have to use staging as their directory.
+// If p exists, it is replaced if policy is ConflictOverwrite, otherwise an error is returned.
+func NewArchiveSink(p string, format ArchiveFormat, staging string, policy ConflictPolicy) (*ArchiveSink, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if policy == ConflictOverwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(p, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &ArchiveSink{
+		format:  format,
+		policy:  policy,
+		staging: newStaging(staging),
+		f:       f,
+		entries: make(map[string][]byte),
+	}
+	switch format {
+	case ArchiveZip, ArchiveCBZ:
+		s.zw = zip.NewWriter(f)
+	case ArchiveTar:
+		s.tw = tar.NewWriter(f)
+	case ArchiveTarGz:
+		s.gz = gzip.NewWriter(f)
+		s.tw = tar.NewWriter(s.gz)
+	}
+	return s, nil
+}
+
+// Create writes the download to a partial file in the staging directory.
+func (s *ArchiveSink) Create(dl *Download) (SinkFile, error) {
+	return s.staging.create(dl)
+}
+
+// Store appends the staged file of dl to the archive. The download's file name is set to the name of its entry.
+func (s *ArchiveSink) Store(dl *Download) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	staged := dl.Path()
+	if s.err != nil {
+		s.staging.done(staged, false)
+		return s.err
+	}
+	name := filepath.ToSlash(dl.File())
+	if s.format == ArchiveCBZ {
+		//comic book readers sort entries by name, the download's id keeps the order the downloads were found in
+		name = fmt.Sprintf("%06d%s", dl.id, strings.ToLower(path.Ext(name)))
+	}
+	name, err := s.claim(name, dl)
+	if err != nil || dl.Skipped != SkipNone {
+		s.staging.done(staged, false)
+		return err
+	}
+	if err := s.add(name, staged); err != nil {
+		return err
+	}
+	if exists, _ := fileExists(staged + SidecarExt); exists {
+		if err := s.add(name+SidecarExt, staged+SidecarExt); err != nil {
+			return err
+		}
+	}
+	s.entries[name] = dl.sum
+	s.staging.done(staged, true)
+	dl.file = name
+	return nil
+}
+
+// claim resolves a conflict between name and an existing entry according to the sink's conflict policy.
+// It returns the name the download is stored under.
+func (s *ArchiveSink) claim(name string, dl *Download) (string, error) {
+	sum, exists := s.entries[name]
+	if !exists {
+		return name, nil
+	}
+	switch s.policy {
+	case ConflictFail:
+		return "", fmt.Errorf("archive entry already exists: %s", name)
+	case ConflictSkip:
+		dl.Skipped = SkipExists
+		return name, nil
+	case ConflictHash:
+		if bytes.Equal(sum, dl.sum) {
+			dl.Skipped = SkipIdentical
+			return name, nil
+		}
+	}
+	return nextFreeName(name, func(candidate string) (bool, error) {
+		_, taken := s.entries[candidate]
+		return taken, nil
+	})
+}
+
+// add writes the file at p to the archive as entry name. An error that occurs once the entry was started breaks
+// the archive.
+func (s *ArchiveSink) add(name, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var w io.Writer
+	if s.zw != nil {
+		header := &zip.FileHeader{Name: name, Modified: info.ModTime(), Method: zip.Deflate}
+		if s.format == ArchiveCBZ {
+			//images are compressed already
+			header.Method = zip.Store
+		}
+		if w, err = s.zw.CreateHeader(header); err != nil {
+			return s.broken(name, err)
+		}
+	} else {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Format:   tar.FormatPAX,
+		}
+		if err := s.tw.WriteHeader(header); err != nil {
+			return s.broken(name, err)
+		}
+		w = s.tw
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return s.broken(name, err)
+	}
+	return nil
+}
+
+// broken notes that the archive is unusable because writing entry name failed with err and returns the error.
+func (s *ArchiveSink) broken(name string, err error) error {
+	s.err = fmt.Errorf("archive is broken, writing entry %s failed: %w", name, err)
+	return s.err
+}
+
+// Exists returns true if the file staged at path was stored in the archive or is still staged.
+func (s *ArchiveSink) Exists(path string) (bool, error) {
+	return s.staging.exists(path)
+}
+
+// Close completes the archive and removes the staging directory. The error that broke the archive is returned
+// if there was one.
+func (s *ArchiveSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.zw != nil {
+		err = s.zw.Close()
+	}
+	if s.tw != nil {
+		err = s.tw.Close()
+	}
+	if s.gz != nil && err == nil {
+		err = s.gz.Close()
+	}
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.staging.remove()
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readArchive returns the content of every entry of the archive at p by the entry's name, in archive order.
+func readArchive(t *testing.T, p string, format ArchiveFormat) ([]string, map[string]string) {
+	names := make([]string, 0)
+	content := make(map[string]string)
+	if format == ArchiveZip || format == ArchiveCBZ {
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, f.Name)
+			content[f.Name] = string(b)
+		}
+		return names, content
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if format == ArchiveTarGz {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+		content[h.Name] = string(b)
+	}
+	return names, content
+}
+
+func TestArchiveSink(t *testing.T) {
+	var tests = []struct {
+		format ArchiveFormat
+		policy ConflictPolicy
+		names  []string
+	}{
+		{ArchiveZip, ConflictRename, []string{"sub/b.jpg", "a.jpg", "a-1.jpg"}},
+		{ArchiveTar, ConflictHash, []string{"sub/b.jpg", "a.jpg"}},
+		{ArchiveTarGz, ConflictSkip, []string{"sub/b.jpg", "a.jpg"}},
+		{ArchiveCBZ, ConflictFail, []string{"000001.jpg", "000002.jpg", "000003.jpg"}},
+	}
+	files := []struct {
+		file    string
+		content string
+	}{
+		{"sub/b.jpg", "b"},
+		{"a.jpg", "a"},
+		{"a.jpg", "a"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		staging := filepath.Join(dir, "staging")
+		if err := os.MkdirAll(filepath.Join(staging, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		p := filepath.Join(dir, "archive."+test.format.String())
+		sink, err := NewArchiveSink(p, test.format, staging, test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, f := range files {
+			writeTestFile(t, filepath.Join(staging, filepath.FromSlash(f.file)), f.content)
+			sum := sha256.Sum256([]byte(f.content))
+			dl := &Download{dir: staging, file: filepath.FromSlash(f.file), id: uint64(i + 1), sum: sum[:]}
+			if err := sink.Store(dl); err != nil {
+				t.Errorf("%s: %v", test.format, err)
+			}
+			if exists, _ := fileExists(filepath.Join(staging, filepath.FromSlash(f.file))); exists {
+				t.Errorf("%s: staged file %q was not removed", test.format, f.file)
+			}
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(staging); !os.IsNotExist(err) {
+			t.Errorf("%s: staging directory was not removed", test.format)
+		}
+		names, content := readArchive(t, p, test.format)
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%s: expected entries %q, got %q", test.format, test.names, names)
+		}
+		if test.format != ArchiveCBZ && content["sub/b.jpg"] != "b" {
+			t.Errorf("%s: unexpected content %q", test.format, content["sub/b.jpg"])
+		}
+		if _, err := NewArchiveSink(p, test.format, staging, test.policy); err == nil {
+			t.Errorf("%s: an existing archive must not be replaced", test.format)
+		}
+	}
+}
+
+func TestArchiveSinkBroken(t *testing.T) {
+	dir := t.TempDir()
+	staging := filepath.Join(dir, "staging")
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := NewArchiveSink(filepath.Join(dir, "archive.tar"), ArchiveTar, staging, ConflictRename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the header of the first entry cannot be written
+	sink.f.Close()
+	errs := make([]error, 0, 2)
+	for i, name := range []string{"a.jpg", "b.jpg"} {
+		writeTestFile(t, filepath.Join(staging, name), name)
+		sum := sha256.Sum256([]byte(name))
+		dl := &Download{dir: staging, file: name, id: uint64(i + 1), sum: sum[:]}
+		errs = append(errs, sink.Store(dl))
+	}
+	//later entries fail with the error that broke the archive
+	if errs[0] == nil || errs[1] != errs[0] {
+		t.Errorf("Expected both entries to fail with the same error, got %v", errs)
+	}
+	if err := sink.Close(); err != errs[0] {
+		t.Errorf("Expected Close to fail with %v, got %v", errs[0], err)
+	}
+}
@@ -0,0 +1,213 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ConflictPolicy decides what happens if a download's file already exists.
+type ConflictPolicy int
+
+const (
+	ConflictFail      ConflictPolicy = iota //the download fails
+	ConflictSkip                            //the download is skipped
+	ConflictOverwrite                       //the existing file is replaced
+	ConflictRename                          //the download is renamed to "name-1.ext", "name-2.ext", ...
+	ConflictHash                            //the download is skipped if it is identical to the existing file, otherwise renamed
+)
+
+// ParseConflictPolicy converts "fail", "skip", "overwrite", "rename" or "hash" into a ConflictPolicy.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch strings.ToLower(s) {
+	case "fail":
+		return ConflictFail, nil
+	case "skip":
+		return ConflictSkip, nil
+	case "overwrite":
+		return ConflictOverwrite, nil
+	case "rename":
+		return ConflictRename, nil
+	case "hash":
+		return ConflictHash, nil
+	}
+	return ConflictFail, fmt.Errorf("Unknown conflict policy %q", s)
+}
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictFail:
+		return "fail"
+	case ConflictSkip:
+		return "skip"
+	case ConflictOverwrite:
+		return "overwrite"
+	case ConflictRename:
+		return "rename"
+	case ConflictHash:
+		return "hash"
+	}
+	return "unknown"
+}
+
+// placeMu serialises the placement of finished files, so that concurrent downloads cannot claim the same name.
+var placeMu sync.Mutex
+
+// place moves the file at src to newfile relative to the download directory, resolving a conflict with an existing
+// file according to the download's conflict policy. The download's file name is updated. If the policy leads to
+// the download being skipped, src is removed and the download's Skipped field is set.
+func (dl *Download) place(src string, newfile string) error {
+	placeMu.Lock()
+	defer placeMu.Unlock()
+	dst := filepath.Join(dl.dir, newfile)
+	if dst != src {
+		exists, err := fileExists(dst)
+		if err != nil {
+			return err
+		}
+		if exists && dst != dl.previous {
+			switch dl.conflictPolicy() {
+			case ConflictFail:
+				return fmt.Errorf("file already exists: %s", dst)
+			case ConflictSkip:
+				os.Remove(src)
+				dl.file = newfile
+				dl.Skipped = SkipExists
+				return nil
+			case ConflictOverwrite:
+				//os.Rename replaces dst
+			case ConflictHash:
+				same, err := sameContent(src, dst)
+				if err != nil {
+					return err
+				}
+				if same {
+					os.Remove(src)
+					dl.file = newfile
+					dl.Skipped = SkipIdentical
+					return nil
+				}
+				fallthrough
+			case ConflictRename:
+				if dst, err = freeName(dst); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+	}
+	rel, err := filepath.Rel(dl.dir, dst)
+	if err != nil {
+		return err
+	}
+	dl.file = rel
+	dl.tempname = false
+	return nil
+}
+
+// checkConflict is called before a download starts. It fails if the download's file exists and the conflict policy
+// is ConflictFail, it marks the download as skipped if the policy is ConflictSkip. An earlier version of a revalidated
+// download is not a conflict.
+func (dl *Download) checkConflict() error {
+	exists, err := dl.Exists()
+	if err != nil || !exists || dl.Path() == dl.previous {
+		return err
+	}
+	switch dl.conflictPolicy() {
+	case ConflictFail:
+		return fmt.Errorf("file already exists: %s", dl.Path())
+	case ConflictSkip:
+		dl.Skipped = SkipExists
+	}
+	return nil
+}
+
+// conflictPolicy returns the conflict policy of the download, the deprecated AllowOverride is honoured as long as
+// OnConflict has its default value.
+func (dl *Download) conflictPolicy() ConflictPolicy {
+	if dl.AllowOverride && dl.OnConflict == ConflictFail {
+		return ConflictOverwrite
+	}
+	return dl.OnConflict
+}
+
+// freeName returns the first path of the form "name-1.ext", "name-2.ext", ... that does not exist.
+func freeName(p string) (string, error) {
+	return nextFreeName(p, fileExists)
+}
+
+// nextFreeName returns the first name of the form "name-1.ext", "name-2.ext", ... that is not taken.
+func nextFreeName(p string, taken func(string) (bool, error)) (string, error) {
+	ext := filepath.Ext(p)
+	if !plausibleExt(ext) {
+		ext = ""
+	}
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		exists, err := taken(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+	}
+}
+
+func fileExists(p string) (bool, error) {
+	_, err := os.Lstat(p)
+	if err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// sameContent compares two files by their size and SHA-256.
+func sameContent(a, b string) (bool, error) {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if ia.Size() != ib.Size() {
+		return false, nil
+	}
+	ha, err := fileSHA256(a)
+	if err != nil {
+		return false, err
+	}
+	hb, err := fileSHA256(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ha, hb), nil
+}
+
+func fileSHA256(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
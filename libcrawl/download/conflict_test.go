@@ -0,0 +1,104 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, p, content string) {
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlace(t *testing.T) {
+	var tests = []struct {
+		policy  ConflictPolicy
+		content string
+		file    string
+		skipped SkipReason
+		fails   bool
+	}{
+		{ConflictFail, "new", "", SkipNone, true},
+		{ConflictSkip, "new", "a.jpg", SkipExists, false},
+		{ConflictOverwrite, "new", "a.jpg", SkipNone, false},
+		{ConflictRename, "new", "a-1.jpg", SkipNone, false},
+		{ConflictHash, "old", "a.jpg", SkipIdentical, false},
+		{ConflictHash, "new", "a-1.jpg", SkipNone, false},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		writeTestFile(t, filepath.Join(dir, "a.jpg"), "old")
+		src := filepath.Join(dir, "download.part")
+		writeTestFile(t, src, test.content)
+		dl := &Download{dir: dir, OnConflict: test.policy}
+		err := dl.place(src, "a.jpg")
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.policy, err)
+			continue
+		}
+		if dl.File() != test.file || dl.Skipped != test.skipped {
+			t.Errorf("%s: expected file %q (%s), got %q (%s)", test.policy, test.file, test.skipped, dl.File(), dl.Skipped)
+		}
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Errorf("%s: source file was not removed", test.policy)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, test.file))
+		if err != nil {
+			t.Errorf("%s: %v", test.policy, err)
+		} else if test.skipped == SkipNone && string(content) != test.content {
+			t.Errorf("%s: expected content %q, got %q", test.policy, test.content, content)
+		}
+	}
+}
+
+func TestFreeName(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.tar"), "")
+	writeTestFile(t, filepath.Join(dir, "a-1.tar"), "")
+	got, err := freeName(filepath.Join(dir, "a.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "a-2.tar"); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestAllowOverride(t *testing.T) {
+	var tests = []struct {
+		policy ConflictPolicy
+		want   ConflictPolicy
+	}{
+		{ConflictFail, ConflictOverwrite},
+		{ConflictRename, ConflictRename},
+		{ConflictSkip, ConflictSkip},
+	}
+	for _, test := range tests {
+		dl := &Download{OnConflict: test.policy, AllowOverride: true}
+		if got := dl.conflictPolicy(); got != test.want {
+			t.Errorf("%s: expected %s, got %s", test.policy, test.want, got)
+		}
+	}
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.jpg"), "old")
+	src := filepath.Join(dir, "download.part")
+	writeTestFile(t, src, "new")
+	dl := &Download{dir: dir, AllowOverride: true}
+	if err := dl.place(src, "a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "a.jpg")); err != nil || string(content) != "new" {
+		t.Errorf("File was not replaced: %q, %v", content, err)
+	}
+}
@@ -0,0 +1,131 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DedupeMode decides what happens to a download whose content is identical to an earlier one.
+type DedupeMode int
+
+const (
+	DedupeOff      DedupeMode = iota //duplicates are not detected
+	DedupeDrop                       //the duplicate is removed and the download is skipped
+	DedupeHardlink                   //the duplicate is replaced by a hard link to the first copy
+	DedupeSymlink                    //the duplicate is replaced by a symbolic link to the first copy
+	DedupeReport                     //the duplicate is kept, see Download.Duplicate
+)
+
+// ParseDedupeMode converts "off", "drop", "hardlink", "symlink" or "report" into a DedupeMode.
+func ParseDedupeMode(s string) (DedupeMode, error) {
+	switch strings.ToLower(s) {
+	case "off":
+		return DedupeOff, nil
+	case "drop":
+		return DedupeDrop, nil
+	case "hardlink":
+		return DedupeHardlink, nil
+	case "symlink":
+		return DedupeSymlink, nil
+	case "report":
+		return DedupeReport, nil
+	}
+	return DedupeOff, fmt.Errorf("Unknown dedupe mode %q", s)
+}
+
+func (m DedupeMode) String() string {
+	switch m {
+	case DedupeOff:
+		return "off"
+	case DedupeDrop:
+		return "drop"
+	case DedupeHardlink:
+		return "hardlink"
+	case DedupeSymlink:
+		return "symlink"
+	case DedupeReport:
+		return "report"
+	}
+	return "unknown"
+}
+
+// dedupeIndex maps the SHA-256 of every file seen during a crawl to the path of its first copy.
+type dedupeIndex struct {
+	mu    sync.Mutex
+	files map[string]string
+	sink  Sink //tells whether a first copy still exists
+}
+
+func newDedupeIndex(sink Sink) *dedupeIndex {
+	return &dedupeIndex{files: make(map[string]string), sink: sink}
+}
+
+// add makes path the first copy of content with SHA-256 sum, unless another copy is known already.
+func (idx *dedupeIndex) add(sum []byte, path string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	key := hex.EncodeToString(sum)
+	if _, ok := idx.files[key]; !ok {
+		idx.files[key] = path
+	}
+}
+
+// first returns the path of the first copy of the completed download dl. If dl is the first copy, or the first copy
+// does not exist anymore, dl is registered as the first copy and an empty string is returned.
+func (idx *dedupeIndex) first(dl *Download) string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	key := hex.EncodeToString(dl.sum)
+	path := dl.Path()
+	if first, ok := idx.files[key]; ok && first != path {
+		if exists, _ := idx.sink.Exists(first); exists {
+			return first
+		}
+	}
+	idx.files[key] = path
+	return ""
+}
+
+// dedupe checks whether the completed download dl is a duplicate and handles it according to mode.
+func (idx *dedupeIndex) dedupe(dl *Download, mode DedupeMode) error {
+	first := idx.first(dl)
+	if first == "" {
+		return nil
+	}
+	dl.duplicate = first
+	switch mode {
+	case DedupeDrop:
+		dl.Skipped = SkipDuplicate
+		return os.Remove(dl.Path())
+	case DedupeHardlink:
+		return dl.replaceWithLink(first, os.Link)
+	case DedupeSymlink:
+		target, err := filepath.Rel(filepath.Dir(dl.Path()), first)
+		if err != nil {
+			return err
+		}
+		return dl.replaceWithLink(target, os.Symlink)
+	}
+	return nil
+}
+
+// replaceWithLink atomically replaces the download's file by a link to target, link is os.Link or os.Symlink.
+func (dl *Download) replaceWithLink(target string, link func(oldname, newname string) error) error {
+	tmp := filepath.Join(filepath.Dir(dl.Path()), fmt.Sprintf(".bbcrawl-%d-%d.link", os.Getpid(), dl.id))
+	if err := link(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dl.Path()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	dl.linked = true
+	return nil
+}
@@ -0,0 +1,80 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupe(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	var tests = []struct {
+		mode    DedupeMode
+		skipped SkipReason
+		removed bool        //the duplicate's file must not exist
+		link    os.FileMode //expected type of the duplicate's file
+	}{
+		{DedupeDrop, SkipDuplicate, true, 0},
+		{DedupeHardlink, SkipNone, false, 0},
+		{DedupeSymlink, SkipNone, false, os.ModeSymlink},
+		{DedupeReport, SkipNone, false, 0},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		first := filepath.Join(dir, "a.jpg")
+		writeTestFile(t, first, "content")
+		if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(dir, "sub", "b.jpg"), "content")
+		idx := newDedupeIndex(FileSink{})
+		idx.add(sum[:], first)
+		dl := &Download{dir: dir, file: filepath.Join("sub", "b.jpg"), sum: sum[:]}
+		if err := idx.dedupe(dl, test.mode); err != nil {
+			t.Errorf("%s: %v", test.mode, err)
+			continue
+		}
+		if dl.Duplicate() != first || dl.Skipped != test.skipped {
+			t.Errorf("%s: expected duplicate of %q (%s), got %q (%s)", test.mode, first, test.skipped, dl.Duplicate(), dl.Skipped)
+		}
+		info, err := os.Lstat(dl.Path())
+		if test.removed {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s: duplicate was not removed", test.mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.mode, err)
+			continue
+		}
+		if info.Mode().Type() != test.link {
+			t.Errorf("%s: expected file type %v, got %v", test.mode, test.link, info.Mode().Type())
+		}
+		if content, err := os.ReadFile(dl.Path()); err != nil || string(content) != "content" {
+			t.Errorf("%s: duplicate is unreadable: %v", test.mode, err)
+		}
+	}
+}
+
+func TestDedupeFirstCopy(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha256.Sum256([]byte("content"))
+	idx := newDedupeIndex(FileSink{})
+	idx.add(sum[:], filepath.Join(dir, "deleted.jpg"))
+	writeTestFile(t, filepath.Join(dir, "a.jpg"), "content")
+	dl := &Download{dir: dir, file: "a.jpg", sum: sum[:]}
+	if err := idx.dedupe(dl, DedupeDrop); err != nil {
+		t.Fatal(err)
+	}
+	if dl.Duplicate() != "" || dl.Skipped != SkipNone {
+		t.Errorf("A missing first copy must be replaced, got duplicate %q (%s)", dl.Duplicate(), dl.Skipped)
+	}
+	if again := idx.first(dl); again != "" {
+		t.Errorf("A download must not be a duplicate of itself, got %q", again)
+	}
+}
@@ -0,0 +1,80 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+)
+
+// headerLen is the maximum amount of bytes that are buffered to read an image's header. JPEG files may carry
+// large metadata segments in front of the frame header.
+const headerLen = 64 * 1024
+
+// ImageFilter describes the dimensions an image needs to have to be downloaded. Zero values are ignored.
+// The aspect ratio is the width divided by the height.
+type ImageFilter struct {
+	MinWidth  int
+	MinHeight int
+	MinAspect float64
+	MaxAspect float64
+}
+
+func (f ImageFilter) active() bool {
+	return f.MinWidth > 0 || f.MinHeight > 0 || f.MinAspect > 0 || f.MaxAspect > 0
+}
+
+// accepts returns true if an image with the dimensions of cfg passes the filter.
+func (f ImageFilter) accepts(cfg image.Config) bool {
+	if cfg.Width < f.MinWidth || cfg.Height < f.MinHeight {
+		return false
+	}
+	if f.MinAspect > 0 || f.MaxAspect > 0 {
+		if cfg.Height == 0 {
+			return false
+		}
+		aspect := float64(cfg.Width) / float64(cfg.Height)
+		if aspect < f.MinAspect || (f.MaxAspect > 0 && aspect > f.MaxAspect) {
+			return false
+		}
+	}
+	return true
+}
+
+// peekImageConfig decodes the header of the image at the start of r without consuming any bytes. It peeks at
+// a growing amount of bytes, so that no more content than necessary has to be received. The error of
+// image.DecodeConfig is returned if the header cannot be decoded, e.g. because the format is not supported.
+func peekImageConfig(r *bufio.Reader) (image.Config, error) {
+	for n := sniffLen; ; n *= 2 {
+		if n > r.Size() {
+			n = r.Size()
+		}
+		head, err := r.Peek(n)
+		cfg, _, decErr := image.DecodeConfig(bytes.NewReader(head))
+		truncated := errors.Is(decErr, io.ErrUnexpectedEOF) || errors.Is(decErr, io.EOF)
+		if decErr == nil || !truncated || err != nil || n == r.Size() {
+			return cfg, decErr
+		}
+	}
+}
+
+// acceptImage checks the dimensions of the image at the start of r against filter. Content that is not an image
+// or whose format is not supported is accepted.
+func acceptImage(r *bufio.Reader, contentType string, filter ImageFilter) bool {
+	if !filter.active() || !strings.HasPrefix(contentType, "image/") {
+		return true
+	}
+	cfg, err := peekImageConfig(r)
+	if err != nil {
+		return true
+	}
+	return filter.accepts(cfg)
+}
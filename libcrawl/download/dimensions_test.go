@@ -0,0 +1,79 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// countingReader counts the bytes that were read from r, it returns at most 256 bytes per call like a network connection.
+type countingReader struct {
+	r    *bytes.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if len(p) > 256 {
+		p = p[:256]
+	}
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestPeekImageConfig(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 400, 300))
+	rnd := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rnd.Intn(256))
+	}
+	img.SetGray(0, 0, color.Gray{})
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	counter := &countingReader{r: bytes.NewReader(buf.Bytes())}
+	r := bufio.NewReaderSize(counter, headerLen)
+	cfg, err := peekImageConfig(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 400 || cfg.Height != 300 {
+		t.Errorf("Expected 400x300, got %dx%d", cfg.Width, cfg.Height)
+	}
+	if counter.read > sniffLen {
+		t.Errorf("Expected at most %d bytes to be read, got %d of %d", sniffLen, counter.read, buf.Len())
+	}
+	if _, err := peekImageConfig(bufio.NewReader(bytes.NewReader([]byte("no image")))); err == nil {
+		t.Error("Expected an error for content that is no image")
+	}
+}
+
+func TestImageFilter(t *testing.T) {
+	var tests = []struct {
+		filter ImageFilter
+		w, h   int
+		accept bool
+	}{
+		{ImageFilter{}, 16, 16, true},
+		{ImageFilter{MinWidth: 100}, 99, 500, false},
+		{ImageFilter{MinWidth: 100, MinHeight: 100}, 100, 100, true},
+		{ImageFilter{MinHeight: 100}, 500, 99, false},
+		{ImageFilter{MaxAspect: 3}, 600, 100, false},
+		{ImageFilter{MaxAspect: 3}, 300, 100, true},
+		{ImageFilter{MinAspect: 0.5}, 100, 300, false},
+		{ImageFilter{MinAspect: 0.5, MaxAspect: 2}, 100, 100, true},
+	}
+	for _, test := range tests {
+		if got := test.filter.accepts(image.Config{Width: test.w, Height: test.h}); got != test.accept {
+			t.Errorf("%+v with %dx%d: expected %v, got %v", test.filter, test.w, test.h, test.accept, got)
+		}
+	}
+}
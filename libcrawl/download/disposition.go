@@ -0,0 +1,207 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"golang.org/x/net/html/charset"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// dispositionParams splits the parameters of a Content-Disposition header value into a map with lower-case keys.
+// Unlike mime.ParseMediaType it accepts unquoted values that contain spaces and similar violations of RFC 6266
+// that are common in the wild. Quoted values may contain ";" and "=".
+func dispositionParams(v string) map[string]string {
+	params := make(map[string]string)
+	//skip the disposition type
+	i := strings.IndexByte(v, ';')
+	if i < 0 {
+		return params
+	}
+	v = v[i+1:]
+	for len(v) > 0 {
+		var key, val string
+		eq := strings.IndexByte(v, '=')
+		semi := strings.IndexByte(v, ';')
+		if eq < 0 || (semi >= 0 && semi < eq) {
+			//parameter without value
+			if semi < 0 {
+				break
+			}
+			v = v[semi+1:]
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(v[:eq]))
+		v = strings.TrimLeft(v[eq+1:], " \t")
+		if strings.HasPrefix(v, "\"") {
+			val, v = unquote(v)
+			if semi := strings.IndexByte(v, ';'); semi >= 0 {
+				v = v[semi+1:]
+			} else {
+				v = ""
+			}
+		} else if semi := strings.IndexByte(v, ';'); semi >= 0 {
+			val, v = strings.TrimSpace(v[:semi]), v[semi+1:]
+		} else {
+			val, v = strings.TrimSpace(v), ""
+		}
+		if _, exists := params[key]; !exists && len(key) > 0 {
+			params[key] = val
+		}
+	}
+	return params
+}
+
+// unquote reads an RFC 7230 quoted-string from the beginning of s and returns its unescaped content and the rest of s.
+// An unterminated string ends at the end of s.
+func unquote(s string) (string, string) {
+	b := new(strings.Builder)
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:]
+		case '\\':
+			if i+1 < len(s) {
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), ""
+}
+
+// decodeExtValue decodes an RFC 5987 ext-value like this one:
+//
+//	UTF-8''%E2%82%AC.jpg
+//
+// Returns false if the value is malformed or if its charset is unknown.
+func decodeExtValue(v string) (string, bool) {
+	parts := strings.SplitN(v, "'", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	raw, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return "", false
+	}
+	return decodeCharset(raw, parts[0])
+}
+
+// decodeCharset converts s from the named charset to UTF-8.
+func decodeCharset(s string, name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case "utf-8", "utf8", "us-ascii", "":
+		if !utf8.ValidString(s) {
+			return "", false
+		}
+		return s, true
+	}
+	enc, _ := charset.Lookup(name)
+	if enc == nil {
+		return "", false
+	}
+	decoded, err := enc.NewDecoder().String(s)
+	if err != nil {
+		return "", false
+	}
+	return decoded, true
+}
+
+// continuedParam joins RFC 2231 parameter continuations like "filename*0*" and "filename*1" of parameter key.
+func continuedParam(params map[string]string, key string) (string, bool) {
+	type section struct {
+		n       int
+		val     string
+		encoded bool
+	}
+	sections := make([]section, 0, 3)
+	prefix := key + "*"
+	for k, v := range params {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		num := strings.TrimPrefix(k, prefix)
+		encoded := strings.HasSuffix(num, "*")
+		n, err := strconv.Atoi(strings.TrimSuffix(num, "*"))
+		if err != nil {
+			continue
+		}
+		sections = append(sections, section{n: n, val: v, encoded: encoded})
+	}
+	if len(sections) == 0 {
+		return "", false
+	}
+	sort.Slice(sections, func(i, j int) bool { return sections[i].n < sections[j].n })
+	var cs string
+	b := new(strings.Builder)
+	for i, s := range sections {
+		if s.n != i {
+			return "", false
+		}
+		val := s.val
+		if s.encoded {
+			if i == 0 {
+				parts := strings.SplitN(val, "'", 3)
+				if len(parts) != 3 {
+					return "", false
+				}
+				cs, val = parts[0], parts[2]
+			}
+			raw, err := url.PathUnescape(val)
+			if err != nil {
+				return "", false
+			}
+			val = raw
+		}
+		b.WriteString(val)
+	}
+	return decodeCharset(b.String(), cs)
+}
+
+// dispositionFilename returns the file name of a Content-Disposition header value. An RFC 5987 "filename*" parameter
+// is preferred over RFC 2231 continuations, which are preferred over "filename". Directory components are removed.
+// Returns "" if there is no usable name.
+func dispositionFilename(v string) string {
+	params := dispositionParams(v)
+	var name string
+	if ext, ok := params["filename*"]; ok {
+		name, _ = decodeExtValue(ext)
+	}
+	if name == "" {
+		name, _ = continuedParam(params, "filename")
+	}
+	if name == "" {
+		name = params["filename"]
+		//some servers send raw ISO-8859-1
+		if !utf8.ValidString(name) {
+			name, _ = decodeCharset(name, "iso-8859-1")
+		}
+	}
+	//strip paths like "C:\uploads\image.jpg"
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// FileNameFromURL returns the unescaped last segment of u's path or "" if there is none. The segments "." and ".."
+// are no file names. The result is not sanitised, Download.SetFile takes care of that.
+func FileNameFromURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	i := strings.LastIndexByte(u.Path, '/')
+	name := u.Path[i+1:]
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,56 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestDispositionFilename(t *testing.T) {
+	var tests = map[string]string{
+		`attachment; filename="image.jpg"`:                                      "image.jpg",
+		`attachment; filename=image.jpg`:                                        "image.jpg",
+		`ATTACHMENT; FileName="Image.JPG"`:                                      "Image.JPG",
+		`inline; filename="a=b;c.jpg"; size=123`:                                "a=b;c.jpg",
+		`attachment; filename="quoted \"name\".png"`:                            `quoted "name".png`,
+		`attachment; filename*=UTF-8''%E2%82%AC%20rates.jpg`:                    "€ rates.jpg",
+		`attachment; filename="EURO rates.jpg"; filename*=utf-8''%e2%82%ac.jpg`: "€.jpg",
+		`attachment; filename*=iso-8859-1'en'%A3%20rates.jpg`:                   "£ rates.jpg",
+		`attachment; filename*0*=UTF-8''%E2%82%AC; filename*1=".jpg"`:           "€.jpg",
+		`attachment; filename=my holiday photo.jpg`:                             "my holiday photo.jpg",
+		`attachment; filename="C:\\uploads\\scan.png"`:                          "scan.png",
+		`attachment; filename="../../etc/passwd"`:                               "passwd",
+		"attachment; filename=\"caf\xe9.jpg\"":                                  "café.jpg",
+		`attachment; filename=".."`:                                             "",
+		`attachment`:                                                            "",
+		`attachment; size=100`:                                                  "",
+		`attachment; filename*=UNKNOWN-CHARSET''abc`:                            "",
+	}
+	for input, want := range tests {
+		if got := dispositionFilename(input); got != want {
+			t.Errorf("%s: expected %q, got %q", input, want, got)
+		}
+	}
+}
+
+func TestFileNameFromURL(t *testing.T) {
+	var tests = map[string]string{
+		"https://example.net/files/image.jpg":        "image.jpg",
+		"https://example.net/files/my%20photo.jpg?a": "my photo.jpg",
+		"https://example.net/files/":                 "",
+		"https://example.net":                        "",
+		"https://example.net/files/..":               "",
+		"https://example.net/.":                      "",
+	}
+	for input, want := range tests {
+		u, err := url.Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FileNameFromURL(u); got != want {
+			t.Errorf("%s: expected %q, got %q", input, want, got)
+		}
+	}
+}
@@ -4,17 +4,24 @@
 package download
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"github.com/jwdev42/bbcrawl/libhttp/ratelimit"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 )
 
+// maxRateBurst is the maximum amount of bytes a download can read at once if the download speed is limited.
+const maxRateBurst = 32 * 1024
+
 type NoFilenameInContentDisposition struct {
 	url *string
 }
@@ -69,17 +76,48 @@ func (r *threadcounter) zero() bool {
 	return false
 }
 
+// HTTPError is returned if the server answers a download request with a status other than 2xx.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e HTTPError) Error() string {
+	return fmt.Sprintf("Server responded with status %q", e.Status)
+}
+
 type Download struct {
 	Client        *http.Client
 	Addr          *url.URL
-	id            uint64 //the id is assigned by the DownloadDispatcher
+	Page          int      //number of the page the download was found on
+	PageAddr      *url.URL //address of the page the download was found on
+	PostID        string   //id of the post the download belongs to, if any
+	Reason        string   //why the crawler found the download, e.g. the selector of the element that links to it
+	NameTemplate  string   //how AfterDownload names the download after the server sent its name, ignored by the downloader
+	Target        Target   //file system rules for file names, see SanitizeName
+	id            uint64   //the id is assigned by the DownloadDispatcher
 	dir           string
 	file          string
 	tempname      bool
 	header        http.Header
-	AllowOverride bool
+	finalAddr     *url.URL //address after all redirects
+	contentType   string
+	size          int64          //size of the completed download
+	sum           []byte         //SHA-256 of the completed download
+	fixExt        bool           //correct the extension if the file gets renamed
+	OnConflict    ConflictPolicy //what happens if the file already exists
+	previous      string         //path of an earlier version of the download, see Revalidate
+	etag          string         //validators of the earlier version
+	lastModified  string
+	duplicate     string //path of the first copy if the download is a duplicate
+	linked        bool   //the download was replaced by a link to its first copy
 	Err           error
+	Skipped       SkipReason //set if the download was deliberately not completed
 	AfterDownload func(*Download)
+
+	// Deprecated: AllowOverride replaces an existing file like ConflictOverwrite if OnConflict is ConflictFail,
+	// the default. Set OnConflict instead.
+	AllowOverride bool
 }
 
 func (dl *Download) checkFilename(name string) {
@@ -89,9 +127,42 @@ func (dl *Download) checkFilename(name string) {
 	if len(name) == 0 {
 		panic("Filename cannot be empty")
 	}
-	if strings.IndexByte(name, os.PathSeparator) >= 0 {
-		panic(fmt.Errorf("Filename \"%s\" is not allowed to contain the directory separator \"%c\"", name, os.PathSeparator))
-	}
+}
+
+// Revalidate turns the download into a conditional request for a file that was downloaded before to path. The request
+// carries the validators etag and lastModified, either may be empty. If the server responds with "304 Not Modified",
+// the download is skipped with SkipNotModified. Otherwise the new version may replace the file at path regardless
+// of the conflict policy.
+func (dl *Download) Revalidate(path, etag, lastModified string) {
+	dl.previous = path
+	dl.etag = etag
+	dl.lastModified = lastModified
+}
+
+// Duplicate returns the path of the first file with the same content if duplicate detection is enabled and
+// the download is a duplicate, otherwise it returns an empty string.
+func (dl *Download) Duplicate() string {
+	return dl.duplicate
+}
+
+// Header returns the http header of the download's response. It is nil until the response was received.
+func (dl *Download) Header() http.Header {
+	return dl.header
+}
+
+// Size returns the size of the completed download in bytes.
+func (dl *Download) Size() int64 {
+	return dl.size
+}
+
+// SHA256 returns the SHA-256 of the completed download's content, it is nil until the download is complete.
+func (dl *Download) SHA256() []byte {
+	return dl.sum
+}
+
+// ContentType returns the media type of the download. It is empty until the download's response was received.
+func (dl *Download) ContentType() string {
+	return dl.contentType
 }
 
 func (dl *Download) Dir() string {
@@ -118,28 +189,41 @@ func (dl *Download) File() string {
 	return dl.file
 }
 
+// Named returns false if the download has no file name yet or an automatically generated one.
+func (dl *Download) Named() bool {
+	return dl.file != "" && !dl.tempname
+}
+
+// NameFromHeader returns the file name sent by the server in the Content-Disposition header.
+// It panics if the download has not finished yet.
 func (dl *Download) NameFromHeader() (string, error) {
-	var filename string
 	header := dl.header
 	if header == nil {
 		panic("NameFromHeader is not supposed to be called until the download has finished")
 	}
-	for _, v := range header.Values("Content-disposition") {
-		for _, field := range strings.Split(v, ";") {
-			trimmedfield := strings.TrimSpace(field)
-			if isHttpFileNameField(trimmedfield) {
-				filename = httpFileNameFieldValue(trimmedfield)
-				if filename == "" {
-					return "", fmt.Errorf("malformed filename in Content-disposition header: %s", field)
-				}
-				break
-			}
+	for _, v := range header.Values("Content-Disposition") {
+		if name := dispositionFilename(v); name != "" {
+			return name, nil
 		}
 	}
-	if filename == "" {
-		return "", fmt.Errorf("No Content-disposition header field found")
+	return "", newNoFilenameInContentDisposition(dl.Addr.String())
+}
+
+// NameFromResponse returns the first usable file name of the following sources: The Content-Disposition header,
+// the last path segment of the url after all redirects, the last path segment of the download's address.
+// It panics if the download has not finished yet.
+func (dl *Download) NameFromResponse() (string, error) {
+	name, err := dl.NameFromHeader()
+	if err == nil {
+		return name, nil
+	}
+	if name := FileNameFromURL(dl.finalAddr); name != "" {
+		return name, nil
+	}
+	if name := FileNameFromURL(dl.Addr); name != "" {
+		return name, nil
 	}
-	return filename, nil
+	return "", fmt.Errorf("URL %q: No filename found in the response", dl.Addr.String())
 }
 
 // Path returns the absolute path to the (to be) downloaded file. Panics if the field "dir" or "file" is zero-valued.
@@ -150,32 +234,28 @@ func (dl *Download) Path() string {
 	return filepath.Join(dl.dir, dl.file)
 }
 
-// Rename changes the file name of a download. If the download already exists, it will be renamed on the file system.
+// Rename changes the path of a download relative to its directory, a "/" in name creates a subdirectory.
+// If the download already exists, it will be renamed on the file system according to the download's conflict policy.
+// The name is sanitised, see SanitizePath.
 func (dl *Download) Rename(name string) error {
 	dl.checkFilename(name)
-	file, err := os.Open(dl.Path())
-	if os.IsNotExist(err) {
-		dl.SetFile(name)
-		return nil
-	} else if err != nil {
+	exists, err := dl.Exists()
+	if err != nil {
 		return err
 	}
-	file.Close()
-	newpath := filepath.Join(dl.dir, name)
-
-	//abort if the new file already exists
-	if newfile, err := os.Open(newpath); err == nil {
-		newfile.Close()
-		return fmt.Errorf("File already exists")
-	} else if !os.IsNotExist(err) {
-		return err
+	if !exists {
+		dl.SetPath(name)
+		return nil
 	}
-
-	if err := os.Rename(dl.Path(), newpath); err != nil {
+	newfile := dl.sanitizePath(name)
+	newpath := filepath.Join(dl.dir, newfile)
+	if !within(dl.dir, newpath) {
+		return fmt.Errorf("%q is outside of the download directory", newpath)
+	}
+	if err := os.MkdirAll(filepath.Dir(newpath), 0755); err != nil {
 		return err
 	}
-	dl.SetFile(name)
-	return nil
+	return dl.place(dl.Path(), newfile)
 }
 
 // SetDir sets the download directory. Panics if dir is not an absolute path.
@@ -199,29 +279,65 @@ func (dl *Download) SetDir(dir string) error {
 	return nil
 }
 
+// SetFile sets the download's file name. The name is sanitised, see SanitizeName.
 func (dl *Download) SetFile(name string) {
 	dl.checkFilename(name)
-	dl.file = name
+	dl.file = SanitizeName(name, dl.Target)
 	dl.tempname = false
 }
 
+// SetPath sets the download's path relative to its directory. Every "/" creates a subdirectory, the subdirectories
+// are created when the download starts. Every path component is sanitised, see SanitizePath.
+func (dl *Download) SetPath(p string) {
+	dl.checkFilename(p)
+	dl.file = dl.sanitizePath(p)
+	dl.tempname = false
+}
+
+func (dl *Download) sanitizePath(p string) string {
+	if clean := SanitizePath(p, dl.Target); clean != "" {
+		return clean
+	}
+	return SanitizeName("", dl.Target)
+}
+
 type DownloadDispatcher struct {
-	max       int
-	counter   *threadcounter
-	dlcounter *DownloadCounter
-	resc      chan *Download //yields the state of finished download routines
+	max         int
+	counter     *threadcounter
+	dlcounter   *DownloadCounter
+	resc        chan *Download //yields the state of finished download routines
+	ctx         context.Context
+	cancel      context.CancelFunc
+	limiter     *ratelimit.Limiter
+	bandwidth   *ratelimit.Bucket //shared by all downloads to limit their combined speed
+	minSize     int64
+	maxSize     int64
+	accept      []string //media type patterns, see MatchType
+	fixExt      bool
+	metadata    Metadata
+	dedupe      DedupeMode
+	imageFilter ImageFilter
+	hook        func(context.Context, *Download)
+	sink        Sink
+	index       *dedupeIndex
+	progress    Progress //nil if the progress is not followed
 }
 
 func NewDownloadDispatcher(downloads int) *DownloadDispatcher {
 	if downloads < 1 {
 		panic("parameter downloads must be > 0")
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	dd := DownloadDispatcher{
 		max:       downloads,
 		counter:   &threadcounter{m: new(sync.Mutex), max: downloads},
 		dlcounter: NewDownloadCounter(),
 		resc:      make(chan *Download, downloads),
+		ctx:       ctx,
+		cancel:    cancel,
+		sink:      FileSink{},
 	}
+	dd.index = newDedupeIndex(dd.sink)
 	return &dd
 }
 
@@ -231,12 +347,101 @@ func (r *DownloadDispatcher) ChooChoo() {
 
 func (r *DownloadDispatcher) Dispatch(dl *Download) {
 	dl.id = r.dlcounter.Count()
+	if r.progress != nil {
+		r.progress.Queued(dl)
+	}
 	for !r.counter.inc() {
 		time.Sleep(time.Millisecond * 50)
 	}
 	go r.downloadJob(dl)
 }
 
+// SetLimiter sets the rate limiter every download has to pass before its request is sent. Passing nil disables rate limiting.
+func (r *DownloadDispatcher) SetLimiter(limiter *ratelimit.Limiter) {
+	r.limiter = limiter
+}
+
+// SetMaxRate limits the combined speed of all downloads to bytesPerSecond. A value < 1 removes the limit.
+func (r *DownloadDispatcher) SetMaxRate(bytesPerSecond int64) {
+	if bytesPerSecond < 1 {
+		r.bandwidth = nil
+		return
+	}
+	burst := bytesPerSecond
+	if burst > maxRateBurst {
+		burst = maxRateBurst
+	}
+	r.bandwidth = ratelimit.NewBucket(float64(bytesPerSecond), int(burst))
+}
+
+// SetSizeLimits makes the dispatcher skip downloads that are smaller than min or larger than max bytes.
+// A limit < 1 is ignored.
+func (r *DownloadDispatcher) SetSizeLimits(min, max int64) {
+	r.minSize, r.maxSize = min, max
+}
+
+// SetAccept makes the dispatcher skip downloads whose media type does not match any of the patterns, see MatchType.
+// An empty list accepts everything.
+func (r *DownloadDispatcher) SetAccept(patterns []string) {
+	r.accept = patterns
+}
+
+// SetMetadata selects where the dispatcher stores the origin of completed downloads.
+func (r *DownloadDispatcher) SetMetadata(m Metadata) {
+	r.metadata = m
+}
+
+// SetImageFilter makes the dispatcher skip images whose dimensions don't pass filter. The dimensions are read from
+// the image's header, so that the rest of the image is not received.
+func (r *DownloadDispatcher) SetImageFilter(filter ImageFilter) {
+	r.imageFilter = filter
+}
+
+// SetSink sets the sink the content of downloads is written to, the default is a FileSink.
+func (r *DownloadDispatcher) SetSink(sink Sink) {
+	r.sink = sink
+	r.index.sink = sink
+}
+
+// SetHook sets an AfterDownload function that is called for every completed download after the download's own
+// AfterDownload function, duplicate handling and metadata. The hook can let the download fail by setting its Err field.
+// The context passed to the hook is cancelled when the dispatcher is aborted.
+func (r *DownloadDispatcher) SetHook(hook func(context.Context, *Download)) {
+	r.hook = hook
+}
+
+// SetProgress makes the dispatcher report the state and the received bytes of every download to p.
+func (r *DownloadDispatcher) SetProgress(p Progress) {
+	r.progress = p
+}
+
+// SetDedupe selects what happens to downloads whose content is identical to an earlier download.
+func (r *DownloadDispatcher) SetDedupe(mode DedupeMode) {
+	r.dedupe = mode
+}
+
+// AddKnownFile registers the existing file at path with SHA-256 sum for duplicate detection,
+// e.g. a file from a previous crawl.
+func (r *DownloadDispatcher) AddKnownFile(sum []byte, path string) {
+	r.index.add(sum, path)
+}
+
+// SetFixExtensions enables or disables the correction of file extensions that do not match a download's media type.
+func (r *DownloadDispatcher) SetFixExtensions(fix bool) {
+	r.fixExt = fix
+}
+
+// Abort cancels all running downloads. Their partially written files are removed.
+// Downloads that are dispatched after Abort was called fail immediately.
+func (r *DownloadDispatcher) Abort() {
+	r.cancel()
+}
+
+// Aborted returns true if Abort was called.
+func (r *DownloadDispatcher) Aborted() bool {
+	return r.ctx.Err() != nil
+}
+
 func (r *DownloadDispatcher) Close() {
 	for !r.counter.zero() {
 		time.Sleep(time.Millisecond * 50)
@@ -262,79 +467,175 @@ func (r *DownloadDispatcher) prepareJob(dl *Download) error {
 		dl.file = fmt.Sprintf("%d.download", dl.id)
 		dl.tempname = true
 	}
-
-	//prevent an overridden file if not allowed
-	if !dl.AllowOverride {
-		if ex, err := dl.Exists(); ex {
-			return fmt.Errorf("file already exists: %s", dl.Path())
-		} else if err != nil {
+	if !within(dl.dir, dl.Path()) {
+		return fmt.Errorf("file %q is outside of the download directory %q", dl.file, dl.dir)
+	}
+	if sub := filepath.Dir(dl.Path()); sub != dl.dir {
+		if err := os.MkdirAll(sub, 0755); err != nil {
 			return err
 		}
 	}
-	return nil
+	return dl.checkConflict()
 }
 
 func (r *DownloadDispatcher) downloadJob(dl *Download) {
 	defer func() {
+		if r.progress != nil {
+			r.progress.Finished(dl)
+		}
 		r.resc <- dl
 	}()
 
+	//the crawler may have decided to skip the download already
+	if dl.Skipped != SkipNone {
+		return
+	}
+
 	//prepare the download
-	if err := r.prepareJob(dl); err != nil {
+	if err := r.prepareJob(dl); err != nil || dl.Skipped != SkipNone {
 		dl.Err = err
 		return
 	}
 
 	//open connection
-	resp, err := dl.Client.Get(dl.Addr.String())
+	if err := r.limiter.Wait(r.ctx, dl.Addr); err != nil {
+		dl.Err = err
+		return
+	}
+	req, err := http.NewRequestWithContext(r.ctx, "GET", dl.Addr.String(), nil)
+	if err != nil {
+		dl.Err = err
+		return
+	}
+	if dl.etag != "" {
+		req.Header.Set("If-None-Match", dl.etag)
+	}
+	if dl.lastModified != "" {
+		req.Header.Set("If-Modified-Since", dl.lastModified)
+	}
+	resp, err := dl.Client.Do(req)
 	if err != nil {
 		dl.Err = err
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && dl.previous != "" {
+		dl.Skipped = SkipNotModified
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		dl.Err = HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+		return
+	}
 
 	//copy http header fields
 	dl.header = resp.Header.Clone()
+	dl.finalAddr = resp.Request.URL
 
-	//create the local file
-	f, err := os.Create(dl.Path())
+	//skip files with a known size that is out of bounds
+	if resp.ContentLength >= 0 {
+		if r.minSize > 0 && resp.ContentLength < r.minSize {
+			dl.Skipped = SkipTooSmall
+			return
+		}
+		if r.maxSize > 0 && resp.ContentLength > r.maxSize {
+			dl.Skipped = SkipTooLarge
+			return
+		}
+	}
+	var body io.Reader = resp.Body
+	if r.progress != nil {
+		r.progress.Started(dl, resp.ContentLength)
+		body = &progressReader{r: body, dl: dl, progress: r.progress}
+	}
+	body = ratelimit.NewReader(r.ctx, body, r.bandwidth)
+	if r.maxSize > 0 {
+		body = &sizeLimitReader{r: body, max: r.maxSize}
+	}
+
+	//determine the media type, sniff the content if the server didn't send a meaningful one
+	buffered := bufio.NewReaderSize(body, headerLen)
+	head, _ := buffered.Peek(sniffLen)
+	dl.contentType = detectType(resp.Header.Get("Content-Type"), head)
+	if !MatchType(r.accept, dl.contentType) {
+		dl.Skipped = SkipContentType
+		return
+	}
+	if !acceptImage(buffered, dl.contentType, r.imageFilter) {
+		dl.Skipped = SkipDimensions
+		return
+	}
+	dl.fixExt = r.fixExt
+	if r.fixExt {
+		if name := fixExtension(dl.file, dl.contentType); name != dl.file {
+			dl.file = name
+			if err := dl.checkConflict(); err != nil || dl.Skipped != SkipNone {
+				dl.Err = err
+				return
+			}
+		}
+	}
+
+	//write the received content to the sink, the file system sink uses a partial file that gets its final name
+	//once it is complete
+	part, err := r.sink.Create(dl)
 	if err != nil {
 		dl.Err = err
 		return
 	}
-	defer f.Close()
-
-	//copy received content to the local file
-	if _, err := io.Copy(f, resp.Body); err != nil {
+	sum := sha256.New()
+	size, err := io.Copy(io.MultiWriter(part, sum), buffered)
+	if err != nil {
+		part.Discard()
+		if errors.Is(err, errTooLarge) {
+			dl.Skipped = SkipTooLarge
+		} else {
+			dl.Err = err
+		}
+		return
+	}
+	if r.minSize > 0 && size < r.minSize {
+		part.Discard()
+		dl.Skipped = SkipTooSmall
+		return
+	}
+	dl.size, dl.sum = size, sum.Sum(nil)
+	if err := part.Commit(); err != nil || dl.Skipped != SkipNone {
 		dl.Err = err
 		return
 	}
+	//keep the server's modification time, renaming the file preserves it
+	metaErr := dl.setModTime()
 
-	//call AfterDownload routine if available
-	if dl.AfterDownload != nil {
-		dl.AfterDownload(dl)
+	//the download's own AfterDownload routine may rename the file, the hook sees the file after post-processing
+	postProcess := func(dl *Download) {
+		if r.dedupe != DedupeOff {
+			if err := r.index.dedupe(dl, r.dedupe); err != nil {
+				dl.Err = fmt.Errorf("cannot handle duplicate of %q: %w", dl.duplicate, err)
+				return
+			}
+			if dl.Skipped != SkipNone {
+				return
+			}
+		}
+		if metaErr == nil {
+			metaErr = dl.writeMetadata(r.metadata)
+		}
 	}
-}
-
-func isHttpFileNameField(input string) bool {
-	if strings.Index(input, "filename=\"") == 0 {
-		return true
+	var hook func(*Download)
+	if r.hook != nil {
+		hook = func(dl *Download) { r.hook(r.ctx, dl) }
 	}
-	return false
-}
-
-// httpFileNameValue returns the filename of a "Content-disposition" HTTP header field, if it is not a filename, it returns "".
-func httpFileNameFieldValue(input string) string {
-	splitted := strings.Split(input, "=")
-	if len(splitted) != 2 {
-		return ""
+	ChainAfterDownload(dl.AfterDownload, postProcess, hook)(dl)
+	if dl.Err != nil || dl.Skipped != SkipNone {
+		return
 	}
-	if splitted[0] != "filename" {
-		return ""
+	if metaErr != nil {
+		metaErr = MetadataError{file: dl.Path(), err: metaErr}
 	}
-	name := strings.Trim(splitted[1], "\"")
-	if strings.IndexByte(name, os.PathSeparator) >= 0 {
-		return ""
+	if err := r.sink.Store(dl); err != nil {
+		dl.Err = err
+		return
 	}
-	return name
+	dl.Err = metaErr
 }
@@ -0,0 +1,231 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRevalidate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v2"`)
+		fmt.Fprint(w, "new")
+	}))
+	defer srv.Close()
+	addr, err := url.Parse(srv.URL + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		etag    string
+		skipped SkipReason
+		content string
+	}{
+		{`"v1"`, SkipNotModified, "old"},
+		{`"v0"`, SkipNone, "new"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		p := filepath.Join(dir, "a.txt")
+		writeTestFile(t, p, "old")
+		dl := &Download{Client: srv.Client(), Addr: addr, OnConflict: ConflictFail}
+		if err := dl.SetDir(dir); err != nil {
+			t.Fatal(err)
+		}
+		dl.SetFile("a.txt")
+		dl.Revalidate(p, test.etag, "")
+		d := NewDownloadDispatcher(1)
+		d.Dispatch(dl)
+		d.Collect()
+		if dl.Err != nil {
+			t.Errorf("%s: %v", test.etag, dl.Err)
+			continue
+		}
+		if dl.Skipped != test.skipped {
+			t.Errorf("%s: expected %q, got %q", test.etag, test.skipped, dl.Skipped)
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != test.content {
+			t.Errorf("%s: expected content %q, got %q", test.etag, test.content, content)
+		}
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	addr, err := url.Parse(srv.URL + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	dl := &Download{Client: srv.Client(), Addr: addr, OnConflict: ConflictFail}
+	if err := dl.SetDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	dl.SetFile("a.txt")
+	d := NewDownloadDispatcher(1)
+	d.Dispatch(dl)
+	d.Collect()
+	if err, ok := dl.Err.(HTTPError); !ok || err.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected an HTTPError with status 404, got %v", dl.Err)
+	}
+	if exists, _ := fileExists(filepath.Join(dir, "a.txt")); exists {
+		t.Error("The error page must not be saved")
+	}
+}
+
+// testProgress records the events of a single download.
+type testProgress struct {
+	events []string
+	size   int64
+	bytes  int64
+}
+
+func (p *testProgress) Queued(dl *Download) {
+	p.events = append(p.events, "queued")
+}
+
+func (p *testProgress) Started(dl *Download, size int64) {
+	p.events = append(p.events, "started")
+	p.size = size
+}
+
+func (p *testProgress) Transferred(dl *Download, n int64) {
+	p.bytes += n
+}
+
+func (p *testProgress) Finished(dl *Download) {
+	p.events = append(p.events, "finished")
+}
+
+func TestProgress(t *testing.T) {
+	content := strings.Repeat("x", 100000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		fmt.Fprint(w, content)
+	}))
+	defer srv.Close()
+	var tests = []struct {
+		path   string
+		events string
+		bytes  int64
+	}{
+		{"/a.txt", "queued started finished", int64(len(content))},
+		{"/missing.txt", "queued finished", 0},
+	}
+	for _, test := range tests {
+		addr, err := url.Parse(srv.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dl := &Download{Client: srv.Client(), Addr: addr, OnConflict: ConflictFail}
+		if err := dl.SetDir(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		dl.SetFile("a.txt")
+		p := new(testProgress)
+		d := NewDownloadDispatcher(1)
+		d.SetProgress(p)
+		d.Dispatch(dl)
+		d.Collect()
+		if events := strings.Join(p.events, " "); events != test.events {
+			t.Errorf("%s: expected events %q, got %q", test.path, test.events, events)
+		}
+		if p.bytes != test.bytes || (test.bytes > 0 && p.size != test.bytes) {
+			t.Errorf("%s: expected %d bytes, got %d of %d", test.path, test.bytes, p.bytes, p.size)
+		}
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large.txt":
+			//the body is only sent after a while, a download that reads it takes that long
+			w.Header().Set("Content-Length", "100000")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			fmt.Fprint(w, strings.Repeat("x", 100000))
+		case "/stream.txt":
+			//flushing before the content is written sends the body without a length
+			w.(http.Flusher).Flush()
+			for i := 0; i < 100; i++ {
+				fmt.Fprint(w, strings.Repeat("x", 1000))
+				w.(http.Flusher).Flush()
+			}
+		case "/small.txt":
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, "tiny")
+		}
+	}))
+	defer srv.Close()
+	var tests = []struct {
+		path    string
+		skipped SkipReason
+	}{
+		{"/large.txt", SkipTooLarge},
+		{"/stream.txt", SkipTooLarge},
+		{"/small.txt", SkipTooSmall},
+	}
+	for _, test := range tests {
+		addr, err := url.Parse(srv.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		dl := &Download{Client: srv.Client(), Addr: addr}
+		if err := dl.SetDir(dir); err != nil {
+			t.Fatal(err)
+		}
+		dl.SetFile("a.txt")
+		d := NewDownloadDispatcher(1)
+		d.SetSizeLimits(100, 10000)
+		start := time.Now()
+		d.Dispatch(dl)
+		d.Collect()
+		if dl.Err != nil {
+			t.Errorf("%s: %v", test.path, dl.Err)
+		}
+		if dl.Skipped != test.skipped {
+			t.Errorf("%s: expected %q, got %q", test.path, test.skipped, dl.Skipped)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: download took %s, the body was read", test.path, elapsed)
+		}
+		//neither the file nor the partial file is left behind
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			t.Errorf("%s: file %q was left behind", test.path, e.Name())
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen is the maximum amount of bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// preferredExt maps media types to the file extension that is used if a file's extension has to be corrected.
+// Types that are not listed here fall back to the mime package.
+var preferredExt = map[string]string{
+	"application/pdf":  ".pdf",
+	"application/zip":  ".zip",
+	"audio/mp4":        ".m4a",
+	"audio/mpeg":       ".mp3",
+	"audio/ogg":        ".ogg",
+	"audio/wav":        ".wav",
+	"audio/webm":       ".weba",
+	"image/avif":       ".avif",
+	"image/bmp":        ".bmp",
+	"image/gif":        ".gif",
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"image/svg+xml":    ".svg",
+	"image/tiff":       ".tif",
+	"image/webp":       ".webp",
+	"image/x-icon":     ".ico",
+	"text/html":        ".html",
+	"text/plain":       ".txt",
+	"video/mp4":        ".mp4",
+	"video/mpeg":       ".mpg",
+	"video/quicktime":  ".mov",
+	"video/webm":       ".webm",
+	"video/x-matroska": ".mkv",
+}
+
+// additionalExt lists extensions that are common for a media type, but might be unknown to the mime package.
+var additionalExt = map[string][]string{
+	"image/jpeg": {".jpeg", ".jpe", ".jfif"},
+	"image/tiff": {".tiff"},
+	"text/html":  {".htm"},
+	"video/mpeg": {".mpeg"},
+}
+
+// isGenericType returns true if t says nothing about the actual content.
+func isGenericType(t string) bool {
+	switch t {
+	case "", "application/octet-stream", "binary/octet-stream", "application/force-download", "application/download":
+		return true
+	}
+	return false
+}
+
+// mediaType returns the lower-case media type of a Content-Type header value without parameters.
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	}
+	return strings.ToLower(t)
+}
+
+// detectType determines the media type of a download. It uses the Content-Type header and falls back to
+// content sniffing with http.DetectContentType if the header is missing or generic.
+func detectType(contentType string, head []byte) string {
+	t := mediaType(contentType)
+	if isGenericType(t) && len(head) > 0 {
+		return mediaType(http.DetectContentType(head))
+	}
+	return t
+}
+
+// MatchType returns true if media type t matches one of the patterns. A pattern is either a media type like "video/mp4",
+// a type with a wildcard subtype like "image/*" or "*/*". An empty pattern list matches every type.
+func MatchType(patterns []string, t string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	t = strings.ToLower(t)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == "*/*" || p == t {
+			return true
+		}
+		if strings.HasSuffix(p, "/*") && strings.HasPrefix(t, p[:len(p)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// extensionsByType returns all known file extensions for media type t, the preferred extension comes first.
+func extensionsByType(t string) []string {
+	exts := make([]string, 0, 5)
+	if ext, ok := preferredExt[t]; ok {
+		exts = append(exts, ext)
+	}
+	exts = append(exts, additionalExt[t]...)
+	if known, err := mime.ExtensionsByType(t); err == nil {
+		exts = append(exts, known...)
+	}
+	return exts
+}
+
+// fixExtension returns name with an extension that matches media type t. The name is returned unchanged if its extension
+// already matches or if no extension is known for t.
+func fixExtension(name string, t string) string {
+	if isGenericType(t) {
+		return name
+	}
+	exts := extensionsByType(t)
+	if len(exts) == 0 {
+		return name
+	}
+	ext := path.Ext(name)
+	if !plausibleExt(ext) {
+		//"127.0.0.1-name" has no extension that could be replaced
+		return name + exts[0]
+	}
+	for _, e := range exts {
+		if strings.EqualFold(e, ext) {
+			return name
+		}
+	}
+	return strings.TrimSuffix(name, ext) + exts[0]
+}
+
+// plausibleExt returns true if ext looks like a file extension, i.e. a dot followed by up to 8 letters or digits.
+func plausibleExt(ext string) bool {
+	if len(ext) < 2 || len(ext) > 9 {
+		return false
+	}
+	for _, c := range ext[1:] {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
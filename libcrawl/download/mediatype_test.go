@@ -0,0 +1,66 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"testing"
+)
+
+func TestMatchType(t *testing.T) {
+	patterns := []string{"image/*", "video/mp4"}
+	for _, v := range []string{"image/jpeg", "IMAGE/PNG", "video/mp4"} {
+		if !MatchType(patterns, v) {
+			t.Errorf("%q should match %v", v, patterns)
+		}
+	}
+	for _, v := range []string{"video/webm", "text/html", "imagex/png", ""} {
+		if MatchType(patterns, v) {
+			t.Errorf("%q should not match %v", v, patterns)
+		}
+	}
+	if !MatchType(nil, "text/html") {
+		t.Error("An empty pattern list should match everything")
+	}
+}
+
+func TestDetectType(t *testing.T) {
+	png := []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+	var tests = []struct {
+		header string
+		head   []byte
+		want   string
+	}{
+		{"image/jpeg", png, "image/jpeg"},
+		{"Text/HTML; charset=UTF-8", nil, "text/html"},
+		{"", png, "image/png"},
+		{"application/octet-stream", png, "image/png"},
+		{"application/octet-stream", nil, "application/octet-stream"},
+		{"", []byte("<!DOCTYPE html><html></html>"), "text/html"},
+	}
+	for _, test := range tests {
+		if got := detectType(test.header, test.head); got != test.want {
+			t.Errorf("header %q: expected %q, got %q", test.header, test.want, got)
+		}
+	}
+}
+
+func TestFixExtension(t *testing.T) {
+	var tests = []struct {
+		name, mediatype, want string
+	}{
+		{"1-1.jpg", "image/jpeg", "1-1.jpg"},
+		{"1-1.JPEG", "image/jpeg", "1-1.JPEG"},
+		{"image.php", "image/png", "image.png"},
+		{"attachment", "image/gif", "attachment.gif"},
+		{"error.jpg", "text/html", "error.html"},
+		{"movie.mp4", "application/octet-stream", "movie.mp4"},
+		{"data.xyz", "application/x-unknown-type", "data.xyz"},
+		{"127.0.0.1-4f2a", "image/png", "127.0.0.1-4f2a.png"},
+	}
+	for _, test := range tests {
+		if got := fixExtension(test.name, test.mediatype); got != test.want {
+			t.Errorf("fixExtension(%q, %q): expected %q, got %q", test.name, test.mediatype, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Metadata selects where the origin of a completed download is stored.
+type Metadata int
+
+const (
+	MetadataNone    Metadata = iota //no metadata is stored
+	MetadataSidecar                 //metadata is written to "<file>.json"
+	MetadataXattr                   //metadata is written to extended file attributes
+)
+
+// SidecarExt is appended to a download's file name to get the name of its sidecar file.
+const SidecarExt = ".json"
+
+// ParseMetadata converts "none", "sidecar" or "xattr" into a Metadata value.
+func ParseMetadata(s string) (Metadata, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return MetadataNone, nil
+	case "sidecar":
+		return MetadataSidecar, nil
+	case "xattr":
+		if !xattrSupported {
+			return MetadataNone, fmt.Errorf("Extended attributes are not supported on this platform")
+		}
+		return MetadataXattr, nil
+	}
+	return MetadataNone, fmt.Errorf("Unknown metadata mode %q", s)
+}
+
+func (m Metadata) String() string {
+	switch m {
+	case MetadataNone:
+		return "none"
+	case MetadataSidecar:
+		return "sidecar"
+	case MetadataXattr:
+		return "xattr"
+	}
+	return "unknown"
+}
+
+// MetadataError is returned if a download is complete, but its timestamp or metadata could not be written.
+type MetadataError struct {
+	file string
+	err  error
+}
+
+func (e MetadataError) Error() string {
+	return fmt.Sprintf("Cannot write metadata of file %q", e.file)
+}
+
+func (e MetadataError) Unwrap() error {
+	return e.err
+}
+
+// metadata describes the origin of a download.
+type metadata struct {
+	URL         string `json:"url"`
+	PageURL     string `json:"page_url,omitempty"`
+	PostID      string `json:"post_id,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+func (dl *Download) metadata() *metadata {
+	m := &metadata{URL: dl.Addr.String(), PostID: dl.PostID, ContentType: dl.contentType}
+	if dl.PageAddr != nil {
+		m.PageURL = dl.PageAddr.String()
+	}
+	return m
+}
+
+// setModTime sets the modification time of the download's file to the Last-Modified date sent by the server.
+// Nothing happens if the server did not send a valid date.
+func (dl *Download) setModTime() error {
+	mtime, err := http.ParseTime(dl.header.Get("Last-Modified"))
+	if err != nil {
+		return nil
+	}
+	return os.Chtimes(dl.Path(), time.Now(), mtime)
+}
+
+// writeMetadata stores the origin of the download as selected by mode.
+func (dl *Download) writeMetadata(mode Metadata) error {
+	m := dl.metadata()
+	switch mode {
+	case MetadataSidecar:
+		b, err := json.MarshalIndent(m, "", "\t")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dl.Path()+SidecarExt, append(b, '\n'), 0644)
+	case MetadataXattr:
+		if dl.linked {
+			//the attributes would belong to the first copy
+			return nil
+		}
+		//the names of the first three attributes follow the freedesktop.org recommendations
+		attrs := []struct{ name, value string }{
+			{"user.xdg.origin.url", m.URL},
+			{"user.xdg.referrer.url", m.PageURL},
+			{"user.mime_type", m.ContentType},
+			{"user.bbcrawl.post_id", m.PostID},
+		}
+		for _, attr := range attrs {
+			if attr.value == "" {
+				continue
+			}
+			if err := setXattr(dl.Path(), attr.name, attr.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
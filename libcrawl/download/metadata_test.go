@@ -0,0 +1,56 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testMetadataDownload(t *testing.T) *Download {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.jpg"), "content")
+	addr, _ := url.Parse("https://example.net/attachment.php?id=1")
+	page, _ := url.Parse("https://example.net/thread.php?page=2")
+	return &Download{Addr: addr, PageAddr: page, PostID: "post_11", dir: dir, file: "a.jpg", contentType: "image/jpeg"}
+}
+
+func TestWriteMetadataSidecar(t *testing.T) {
+	dl := testMetadataDownload(t)
+	if err := dl.writeMetadata(MetadataSidecar); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(dl.Path() + SidecarExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(metadata)
+	if err := json.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+	if want := dl.metadata(); *got != *want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestSetModTime(t *testing.T) {
+	dl := testMetadataDownload(t)
+	mtime := time.Date(2019, 3, 7, 12, 30, 0, 0, time.UTC)
+	dl.header = http.Header{"Last-Modified": {mtime.Format(http.TimeFormat)}}
+	if err := dl.setModTime(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dl.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected modification time %s, got %s", mtime, info.ModTime())
+	}
+}
@@ -0,0 +1,53 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// partSuffix is appended to the file name while a download is still in progress.
+const partSuffix = ".part"
+
+// partialFile is a file that is written under a temporary name. It gets its final name when it is committed.
+type partialFile struct {
+	f  *os.File
+	dl *Download
+}
+
+// createPartial creates a partial file for dl in the directory the download will be placed in. Its name is unique for
+// the download and the process, so that downloads that are going to have the same name cannot interfere.
+func createPartial(dl *Download) (*partialFile, error) {
+	name := fmt.Sprintf(".bbcrawl-%d-%d%s", os.Getpid(), dl.id, partSuffix)
+	f, err := os.Create(filepath.Join(filepath.Dir(dl.Path()), name))
+	if err != nil {
+		return nil, err
+	}
+	return &partialFile{f: f, dl: dl}, nil
+}
+
+func (p *partialFile) Write(b []byte) (int, error) {
+	return p.f.Write(b)
+}
+
+// Commit closes the file and places it at the download's path, see Download.place. The file is removed on failure.
+func (p *partialFile) Commit() error {
+	if err := p.f.Close(); err != nil {
+		os.Remove(p.f.Name())
+		return err
+	}
+	if err := p.dl.place(p.f.Name(), p.dl.file); err != nil {
+		os.Remove(p.f.Name())
+		return err
+	}
+	return nil
+}
+
+// Discard closes and removes the file.
+func (p *partialFile) Discard() {
+	p.f.Close()
+	os.Remove(p.f.Name())
+}
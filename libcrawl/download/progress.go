@@ -0,0 +1,34 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"io"
+)
+
+// Progress follows every download of a DownloadDispatcher. A download is queued once it was dispatched, it starts
+// when the server's response was accepted and finishes when it is collectable. Downloads that fail or get skipped
+// before their response was accepted finish without being started. The methods are called from the download
+// goroutines, so they must be safe for concurrent use.
+type Progress interface {
+	Queued(dl *Download)
+	Started(dl *Download, size int64) //size is -1 if the server did not send the length of the content
+	Transferred(dl *Download, n int64)
+	Finished(dl *Download)
+}
+
+// progressReader reports every read from the response body of a download to a Progress.
+type progressReader struct {
+	r        io.Reader
+	dl       *Download
+	progress Progress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.progress.Transferred(r.dl, int64(n))
+	}
+	return n, err
+}
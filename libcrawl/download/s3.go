@@ -0,0 +1,299 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libhttp/sigv4"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Config describes the bucket an S3Sink uploads to. Objects are addressed path-style, i.e. as Endpoint/Bucket/Key,
+// which is understood by S3 as well as by compatible servers like MinIO.
+type S3Config struct {
+	Endpoint    *url.URL
+	Region      string
+	Bucket      string
+	Prefix      string //prepended to the key of every object
+	Credentials sigv4.Credentials
+	Client      *http.Client //http.DefaultClient if nil
+}
+
+// ParseS3URL splits an URL like "s3://bucket/prefix/" into bucket and key prefix. A non-empty prefix always ends
+// with a slash. The third return value is false if s is no s3 URL.
+func ParseS3URL(s string) (string, string, bool) {
+	const scheme = "s3://"
+	if len(s) < len(scheme) || !strings.EqualFold(s[:len(scheme)], scheme) {
+		return "", "", false
+	}
+	bucket, prefix, _ := strings.Cut(s[len(scheme):], "/")
+	if bucket == "" {
+		return "", "", false
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	return bucket, prefix, true
+}
+
+// maxS3PutSize is the largest object S3 accepts in a single PUT request.
+const maxS3PutSize = 5 << 30
+
+// S3Sink uploads downloads to an S3-compatible bucket. Every download is written to a staging directory first,
+// so that it can be post-processed like a regular file. Store uploads it and its sidecar file, then the staged
+// files are removed.
+//
+// The conflict policy of the sink applies to object keys, existing objects are detected with HEAD requests.
+// Downloads that are placed in the staging directory should use ConflictRename.
+type S3Sink struct {
+	mu      sync.Mutex
+	cfg     S3Config
+	policy  ConflictPolicy
+	staging *staging
+	objects map[string][]byte        //SHA-256 of every object that is known to exist or is reserved by its name
+	pending map[string]chan struct{} //names whose HEAD request is running, the channel is closed when it is done
+}
+
+// s3Error is the body of an error response.
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+// NewS3Sink checks that the bucket of cfg is accessible and returns a sink that uploads to it. Downloads have to use
+// staging as their directory.
+func NewS3Sink(cfg S3Config, staging string, policy ConflictPolicy) (*S3Sink, error) {
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	s := &S3Sink{
+		cfg:     cfg,
+		policy:  policy,
+		staging: newStaging(staging),
+		objects: make(map[string][]byte),
+		pending: make(map[string]chan struct{}),
+	}
+	resp, err := s.request(http.MethodHead, "", nil, 0, sigv4.EmptyPayload, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bucket %q is not accessible: %s", cfg.Bucket, resp.Status)
+	}
+	return s, nil
+}
+
+// Create writes the download to a partial file in the staging directory.
+func (s *S3Sink) Create(dl *Download) (SinkFile, error) {
+	return s.staging.create(dl)
+}
+
+// Store uploads the staged file of dl. The download's file name is set to the object key without the sink's prefix.
+// Files larger than maxS3PutSize are refused.
+func (s *S3Sink) Store(dl *Download) error {
+	staged := dl.Path()
+	if info, err := os.Stat(staged); err != nil {
+		s.staging.done(staged, false)
+		return err
+	} else if info.Size() > maxS3PutSize {
+		s.staging.done(staged, false)
+		return fmt.Errorf("%s: %d bytes exceed the limit of a single upload of 5 GiB", dl.File(), info.Size())
+	}
+	name, err := s.claim(filepath.ToSlash(dl.File()), dl)
+	if err != nil || dl.Skipped != SkipNone {
+		s.staging.done(staged, false)
+		return err
+	}
+	if err := s.put(name, staged, dl.ContentType()); err != nil {
+		s.release(name)
+		return err
+	}
+	if exists, _ := fileExists(staged + SidecarExt); exists {
+		if err := s.put(name+SidecarExt, staged+SidecarExt, "application/json"); err != nil {
+			return err
+		}
+	}
+	s.staging.done(staged, true)
+	dl.file = name
+	return nil
+}
+
+// claim resolves a conflict between name and an existing object according to the sink's conflict policy.
+// It returns the name the download is uploaded as, the name is reserved until the upload fails.
+func (s *S3Sink) claim(name string, dl *Download) (string, error) {
+	exists, sum, err := s.reserve(name, dl.sum)
+	if err != nil {
+		return "", err
+	}
+	if exists && s.policy != ConflictOverwrite {
+		switch s.policy {
+		case ConflictFail:
+			return "", fmt.Errorf("object already exists: %s", s.cfg.Prefix+name)
+		case ConflictSkip:
+			dl.Skipped = SkipExists
+			return name, nil
+		case ConflictHash:
+			if bytes.Equal(sum, dl.sum) {
+				dl.Skipped = SkipIdentical
+				return name, nil
+			}
+		}
+		return nextFreeName(name, func(candidate string) (bool, error) {
+			exists, _, err := s.reserve(candidate, dl.sum)
+			return exists, err
+		})
+	}
+	if exists {
+		s.mu.Lock()
+		s.objects[name] = dl.sum
+		s.mu.Unlock()
+	}
+	return name, nil
+}
+
+func (s *S3Sink) release(name string) {
+	s.mu.Lock()
+	delete(s.objects, name)
+	s.mu.Unlock()
+}
+
+// reserve returns whether the object name exists and its SHA-256, if it was uploaded by bbcrawl. If it doesn't exist,
+// it is reserved for an upload whose SHA-256 is sum. The HEAD request is sent without holding the sink's lock,
+// concurrent reservations of the same name wait for it.
+func (s *S3Sink) reserve(name string, sum []byte) (bool, []byte, error) {
+	s.mu.Lock()
+	for {
+		if known, ok := s.objects[name]; ok {
+			s.mu.Unlock()
+			return true, known, nil
+		}
+		wait, ok := s.pending[name]
+		if !ok {
+			break
+		}
+		s.mu.Unlock()
+		<-wait
+		s.mu.Lock()
+	}
+	done := make(chan struct{})
+	s.pending[name] = done
+	s.mu.Unlock()
+	exists, known, err := s.lookup(name)
+	s.mu.Lock()
+	delete(s.pending, name)
+	if err == nil && exists {
+		s.objects[name] = known
+	} else if err == nil {
+		s.objects[name] = sum
+	}
+	s.mu.Unlock()
+	close(done)
+	return exists, known, err
+}
+
+// lookup sends a HEAD request for the object name. It returns whether the object exists and its SHA-256, if it was
+// uploaded by bbcrawl.
+func (s *S3Sink) lookup(name string) (bool, []byte, error) {
+	resp, err := s.request(http.MethodHead, s.cfg.Prefix+name, nil, 0, sigv4.EmptyPayload, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		sum, _ := hex.DecodeString(resp.Header.Get("X-Amz-Meta-Sha256"))
+		return true, sum, nil
+	case http.StatusNotFound:
+		return false, nil, nil
+	}
+	return false, nil, fmt.Errorf("HEAD %s: %s", s.cfg.Prefix+name, resp.Status)
+}
+
+// put uploads the file at p as object name.
+func (s *S3Sink) put(name, p, contentType string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	header := http.Header{"X-Amz-Meta-Sha256": {sum}}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	var body io.Reader = f
+	if info.Size() == 0 {
+		body = http.NoBody
+	}
+	resp, err := s.request(http.MethodPut, s.cfg.Prefix+name, body, info.Size(), sum, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	e := new(s3Error)
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(e); err != nil || e.Code == "" {
+		return fmt.Errorf("PUT %s: %s", s.cfg.Prefix+name, resp.Status)
+	}
+	return fmt.Errorf("PUT %s: %s: %s: %s", s.cfg.Prefix+name, resp.Status, e.Code, e.Message)
+}
+
+// request sends a signed request for the object key, the bucket itself is addressed if key is empty.
+func (s *S3Sink) request(method, key string, body io.Reader, size int64, payloadHash string, header http.Header) (*http.Response, error) {
+	u := *s.cfg.Endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = sigv4.Escape(u.Path, false)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	sigv4.Sign(req, payloadHash, s.cfg.Credentials, s.cfg.Region, "s3", time.Now())
+	return s.cfg.Client.Do(req)
+}
+
+// Exists returns true if the file staged at path was uploaded or is still staged.
+func (s *S3Sink) Exists(path string) (bool, error) {
+	return s.staging.exists(path)
+}
+
+// Close removes the staging directory.
+func (s *S3Sink) Close() error {
+	s.staging.remove()
+	return nil
+}
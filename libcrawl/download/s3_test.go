@@ -0,0 +1,249 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/jwdev42/bbcrawl/libhttp/sigv4"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal path-style S3 server with a single bucket.
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string]string
+	types   map[string]string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") ||
+		!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/s3/aws4_request") {
+		http.Error(w, "missing signature", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+s.bucket)
+	if key == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodHead:
+		content, ok := s.objects[key]
+		if key != "" && !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if key != "" {
+			sum := sha256.Sum256([]byte(content))
+			w.Header().Set("X-Amz-Meta-Sha256", hex.EncodeToString(sum[:]))
+		}
+	case http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256(b)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			http.Error(w, "payload hash mismatch", http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = string(b)
+		s.types[key] = r.Header.Get("Content-Type")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	var tests = []struct {
+		policy ConflictPolicy
+		keys   map[string]string
+	}{
+		{ConflictRename, map[string]string{"x/a b.jpg": "old", "x/a b-1.jpg": "a", "x/a b-2.jpg": "old", "x/a b-3.jpg": "new", "x/sub/ü.jpg": "ü"}},
+		{ConflictHash, map[string]string{"x/a b.jpg": "old", "x/a b-1.jpg": "a", "x/a b-2.jpg": "new", "x/sub/ü.jpg": "ü"}},
+		{ConflictSkip, map[string]string{"x/a b.jpg": "old", "x/sub/ü.jpg": "ü"}},
+		{ConflictOverwrite, map[string]string{"x/a b.jpg": "new", "x/sub/ü.jpg": "ü"}},
+	}
+	files := []struct {
+		file    string
+		content string
+	}{
+		{"a b.jpg", "a"},
+		{"a b.jpg", "old"},
+		{"a b.jpg", "new"},
+		{"sub/ü.jpg", "ü"},
+	}
+	for _, test := range tests {
+		fake := &fakeS3{bucket: "bucket", objects: map[string]string{"x/a b.jpg": "old"}, types: make(map[string]string)}
+		srv := httptest.NewServer(fake)
+		endpoint, _ := url.Parse(srv.URL)
+		staging := filepath.Join(t.TempDir(), "staging")
+		if err := os.MkdirAll(filepath.Join(staging, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		cfg := S3Config{
+			Endpoint:    endpoint,
+			Region:      "us-east-1",
+			Bucket:      "bucket",
+			Prefix:      "x/",
+			Credentials: sigv4.Credentials{AccessKey: "key", SecretKey: "secret"},
+		}
+		sink, err := NewS3Sink(cfg, staging, test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			writeTestFile(t, filepath.Join(staging, filepath.FromSlash(f.file)), f.content)
+			sum := sha256.Sum256([]byte(f.content))
+			dl := &Download{dir: staging, file: filepath.FromSlash(f.file), sum: sum[:], contentType: "image/jpeg"}
+			if err := sink.Store(dl); err != nil {
+				t.Errorf("%s: %v", test.policy, err)
+			}
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+		if len(fake.objects) != len(test.keys) {
+			t.Errorf("%s: expected objects %q, got %q", test.policy, test.keys, fake.objects)
+		}
+		for key, content := range test.keys {
+			if fake.objects[key] != content {
+				t.Errorf("%s: expected %q to contain %q, got %q", test.policy, key, content, fake.objects[key])
+			}
+		}
+		if fake.types["x/sub/ü.jpg"] != "image/jpeg" {
+			t.Errorf("%s: unexpected content type %q", test.policy, fake.types["x/sub/ü.jpg"])
+		}
+		if _, err := os.Stat(staging); !os.IsNotExist(err) {
+			t.Errorf("%s: staging directory was not removed", test.policy)
+		}
+	}
+}
+
+// newTestS3Sink returns a sink with the prefix "x/" that uploads to the bucket "bucket" served by handler. The staging
+// directory of the sink is returned too.
+func newTestS3Sink(t *testing.T, handler http.Handler, policy ConflictPolicy) (*S3Sink, string) {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	endpoint, _ := url.Parse(srv.URL)
+	staging := filepath.Join(t.TempDir(), "staging")
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := S3Config{
+		Endpoint:    endpoint,
+		Region:      "us-east-1",
+		Bucket:      "bucket",
+		Prefix:      "x/",
+		Credentials: sigv4.Credentials{AccessKey: "key", SecretKey: "secret"},
+	}
+	sink, err := NewS3Sink(cfg, staging, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink, staging
+}
+
+func TestS3SinkConcurrentClaims(t *testing.T) {
+	fake := &fakeS3{bucket: "bucket", objects: make(map[string]string), types: make(map[string]string)}
+	//the HEAD requests for a.jpg are answered once b.jpg was looked up, which needs them to run concurrently
+	gate := make(chan struct{})
+	var once sync.Once
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && strings.HasSuffix(r.URL.Path, "/x/b.jpg") {
+			once.Do(func() { close(gate) })
+		} else if r.Method == http.MethodHead && strings.Contains(r.URL.Path, "/x/a") {
+			select {
+			case <-gate:
+			case <-time.After(5 * time.Second):
+				http.Error(w, "HEAD requests were not concurrent", http.StatusInternalServerError)
+				return
+			}
+		}
+		fake.ServeHTTP(w, r)
+	})
+	sink, _ := newTestS3Sink(t, handler, ConflictRename)
+	names := make([]string, 3)
+	errs := make([]error, 3)
+	var wg sync.WaitGroup
+	for i, name := range []string{"a.jpg", "a.jpg", "b.jpg"} {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			sum := sha256.Sum256([]byte{byte(i)})
+			names[i], errs[i] = sink.claim(name, &Download{sum: sum[:]})
+		}(i, name)
+		if i == 0 {
+			//let the first claim send its HEAD request
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names[0] == names[1] || names[0]+names[1] != "a.jpga-1.jpg" && names[0]+names[1] != "a-1.jpga.jpg" || names[2] != "b.jpg" {
+		t.Errorf("Unexpected names %q", names)
+	}
+}
+
+func TestS3SinkMaxSize(t *testing.T) {
+	fake := &fakeS3{bucket: "bucket", objects: make(map[string]string), types: make(map[string]string)}
+	sink, staging := newTestS3Sink(t, fake, ConflictRename)
+	f, err := os.Create(filepath.Join(staging, "large.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Truncate(maxS3PutSize + 1)
+	f.Close()
+	if err != nil {
+		t.Skip(err)
+	}
+	if err := sink.Store(&Download{dir: staging, file: "large.bin"}); err == nil {
+		t.Error("A file larger than 5 GiB was stored")
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("Unexpected objects %q", fake.objects)
+	}
+	if _, err := os.Stat(filepath.Join(staging, "large.bin")); !os.IsNotExist(err) {
+		t.Error("The staged file was not removed")
+	}
+}
+
+func TestParseS3URL(t *testing.T) {
+	var tests = []struct {
+		in, bucket, prefix string
+		ok                 bool
+	}{
+		{"s3://bucket", "bucket", "", true},
+		{"S3://bucket/a/b", "bucket", "a/b/", true},
+		{"s3://bucket//a/", "bucket", "a/", true},
+		{"s3:///a", "", "", false},
+		{"/tmp/s3", "", "", false},
+	}
+	for _, test := range tests {
+		bucket, prefix, ok := ParseS3URL(test.in)
+		if bucket != test.bucket || prefix != test.prefix || ok != test.ok {
+			t.Errorf("%q: expected %q, %q, %v, got %q, %q, %v", test.in, test.bucket, test.prefix, test.ok, bucket, prefix, ok)
+		}
+	}
+}
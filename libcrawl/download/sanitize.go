@@ -0,0 +1,157 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNameLen is the maximum length of a file name in bytes that is supported by all common file systems.
+const maxNameLen = 255
+
+// maxExtLen is the maximum length of an extension that is preserved if a file name gets truncated.
+const maxExtLen = 16
+
+// Target selects the file system rules file names are sanitised for.
+type Target int
+
+const (
+	TargetNative   Target = iota //rules of the operating system bbcrawl runs on
+	TargetPosix                  //only "/" and NUL are forbidden
+	TargetWindows                //rules of the Win32 API
+	TargetPortable               //names that are valid on both posix and windows systems
+)
+
+// ParseTarget converts "native", "posix", "windows" or "portable" into a Target.
+func ParseTarget(s string) (Target, error) {
+	switch strings.ToLower(s) {
+	case "native":
+		return TargetNative, nil
+	case "posix":
+		return TargetPosix, nil
+	case "windows":
+		return TargetWindows, nil
+	case "portable":
+		return TargetPortable, nil
+	}
+	return TargetNative, fmt.Errorf("Unknown file system target %q", s)
+}
+
+func (t Target) String() string {
+	switch t {
+	case TargetNative:
+		return "native"
+	case TargetPosix:
+		return "posix"
+	case TargetWindows:
+		return "windows"
+	case TargetPortable:
+		return "portable"
+	}
+	return "unknown"
+}
+
+// resolve replaces TargetNative with the target of the running operating system.
+func (t Target) resolve() Target {
+	if t != TargetNative {
+		return t
+	}
+	if runtime.GOOS == "windows" {
+		return TargetWindows
+	}
+	return TargetPosix
+}
+
+// windowsReserved contains device names that cannot be used as a file name on windows, regardless of the extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeName turns name into a single valid path component for target. The name is normalised to Unicode NFC,
+// directory separators, control characters and characters forbidden by the target are replaced by "_",
+// reserved names are prefixed by "_" and names that are too long are truncated while their extension is preserved.
+// The result is never empty, "." or "..".
+func SanitizeName(name string, target Target) string {
+	target = target.resolve()
+	windows := target == TargetWindows || target == TargetPortable
+	name = strings.ToValidUTF8(name, "_")
+	name = norm.NFC.String(name)
+	b := new(strings.Builder)
+	for _, c := range name {
+		switch {
+		case c < 0x20 || c == 0x7f || (c >= 0x80 && c <= 0x9f):
+			c = '_'
+		case c == '/':
+			c = '_'
+		case windows && strings.ContainsRune(`<>:"\|?*`, c):
+			c = '_'
+		}
+		b.WriteRune(c)
+	}
+	name = b.String()
+	if windows {
+		//windows silently strips trailing dots and spaces
+		name = strings.TrimRight(name, ". ")
+		base := name
+		if i := strings.IndexByte(base, '.'); i >= 0 {
+			base = base[:i]
+		}
+		if windowsReserved[strings.ToUpper(strings.TrimSpace(base))] {
+			name = "_" + name
+		}
+	}
+	if name == "" || name == "." || name == ".." {
+		name = "_" + name
+	}
+	return truncateName(name, maxNameLen)
+}
+
+// truncateName shortens name to at most max bytes without splitting a UTF-8 sequence. The extension is preserved
+// unless it is unusually long.
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) > maxExtLen || len(ext) == len(name) {
+		ext = ""
+	}
+	base := name[:len(name)-len(ext)]
+	cut := max - len(ext)
+	for cut > 0 && !utf8.RuneStart(base[cut]) {
+		cut--
+	}
+	return base[:cut] + ext
+}
+
+// SanitizePath sanitises every "/"-separated component of the relative path p with SanitizeName and joins them with
+// the operating system's separator. Empty components are dropped, so the result cannot be absolute.
+func SanitizePath(p string, target Target) string {
+	parts := strings.Split(p, "/")
+	clean := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		clean = append(clean, SanitizeName(part, target))
+	}
+	return filepath.Join(clean...)
+}
+
+// within returns true if path p is located inside directory dir.
+func within(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
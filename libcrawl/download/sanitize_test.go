@@ -0,0 +1,85 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeName(t *testing.T) {
+	var tests = []struct {
+		input  string
+		target Target
+		want   string
+	}{
+		{"image.jpg", TargetPortable, "image.jpg"},
+		{"..", TargetPosix, "_.."},
+		{".", TargetWindows, "_"},
+		{"", TargetPosix, "_"},
+		{"a/b.jpg", TargetPosix, "a_b.jpg"},
+		{`a\b.jpg`, TargetPosix, `a\b.jpg`},
+		{`a\b.jpg`, TargetWindows, "a_b.jpg"},
+		{`what?<now>:*|".png`, TargetPortable, "what__now_____.png"},
+		{"tab\tnew\nline.txt", TargetPosix, "tab_new_line.txt"},
+		{"CON", TargetWindows, "_CON"},
+		{"con.txt", TargetPortable, "_con.txt"},
+		{"Lpt1.tar.gz", TargetWindows, "_Lpt1.tar.gz"},
+		{"CON", TargetPosix, "CON"},
+		{"console.txt", TargetWindows, "console.txt"},
+		{"trailing. . ", TargetWindows, "trailing"},
+		{"café.jpg", TargetPosix, "café.jpg"},
+		{"bad\xffutf8", TargetPosix, "bad_utf8"},
+	}
+	for _, test := range tests {
+		if got := SanitizeName(test.input, test.target); got != test.want {
+			t.Errorf("SanitizeName(%q, %s): expected %q, got %q", test.input, test.target, test.want, got)
+		}
+	}
+}
+
+func TestSanitizeNameLength(t *testing.T) {
+	long := strings.Repeat("ä", 200) + ".jpeg"
+	got := SanitizeName(long, TargetPosix)
+	if len(got) > maxNameLen {
+		t.Errorf("Expected at most %d bytes, got %d", maxNameLen, len(got))
+	}
+	if !strings.HasSuffix(got, ".jpeg") {
+		t.Errorf("Extension was not preserved: %q", got)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("Truncation produced invalid UTF-8: %q", got)
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	var tests = map[string]string{
+		"a/b/c.jpg":       "a/b/c.jpg",
+		"/abs/../x.jpg":   "abs/_../x.jpg",
+		"../../etc/passw": "_../_../etc/passw",
+		"a//b":            "a/b",
+	}
+	for input, want := range tests {
+		if got := SanitizePath(input, TargetPosix); got != want {
+			t.Errorf("SanitizePath(%q): expected %q, got %q", input, want, got)
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	var tests = map[string]bool{
+		"/out/a.jpg":     true,
+		"/out/sub/a.jpg": true,
+		"/out":           false,
+		"/out/../a.jpg":  false,
+		"/outside/a.jpg": false,
+		"/out/..a.jpg":   true,
+	}
+	for p, want := range tests {
+		if got := within("/out", p); got != want {
+			t.Errorf("within(%q, %q): expected %t, got %t", "/out", p, want, got)
+		}
+	}
+}
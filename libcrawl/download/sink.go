@@ -0,0 +1,104 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sink decides where the content of downloads ends up. The DownloadDispatcher writes the content of a download to
+// a SinkFile that is created by the sink. Once the download is committed, it has a file at its path that is
+// post-processed, e.g. renamed by AfterDownload. Finally the download is handed over to the sink's Store method.
+// A Sink must be safe for concurrent use.
+type Sink interface {
+	// Create returns the SinkFile the content of dl is written to.
+	Create(dl *Download) (SinkFile, error)
+	// Store is called for every download that completed successfully, after all post-processing.
+	Store(dl *Download) error
+	// Exists returns true if the sink holds the file that was stored from path.
+	Exists(path string) (bool, error)
+	// Close is called after all downloads were collected.
+	Close() error
+}
+
+// SinkFile receives the content of a single download.
+type SinkFile interface {
+	io.Writer
+	// Commit completes the content, the download's file must exist at its path afterwards unless the
+	// download's conflict policy skips it.
+	Commit() error
+	// Discard throws the content away.
+	Discard()
+}
+
+// FileSink writes downloads to the file system. Files are written under a temporary name and get their final name
+// when they are committed.
+type FileSink struct{}
+
+func (FileSink) Create(dl *Download) (SinkFile, error) {
+	return createPartial(dl)
+}
+
+// Store does nothing, the file already is in its place.
+func (FileSink) Store(dl *Download) error {
+	return nil
+}
+
+func (FileSink) Exists(path string) (bool, error) {
+	return fileExists(path)
+}
+
+func (FileSink) Close() error {
+	return nil
+}
+
+// staging is a directory that holds the downloads of a sink that does not store files in the file system, until
+// they are handed over to the sink's Store method.
+type staging struct {
+	dir    string
+	mu     sync.Mutex
+	stored map[string]bool //staged paths whose files were stored
+}
+
+func newStaging(dir string) *staging {
+	return &staging{dir: dir, stored: make(map[string]bool)}
+}
+
+// create writes the download to a partial file in the staging directory.
+func (s *staging) create(dl *Download) (SinkFile, error) {
+	if !within(s.dir, dl.Path()) {
+		return nil, fmt.Errorf("file %q is outside of the staging directory %q", dl.Path(), s.dir)
+	}
+	return createPartial(dl)
+}
+
+// done removes the staged file and its sidecar file. The staged path is remembered if the file was stored.
+func (s *staging) done(staged string, stored bool) {
+	os.Remove(staged)
+	os.Remove(staged + SidecarExt)
+	if stored {
+		s.mu.Lock()
+		s.stored[staged] = true
+		s.mu.Unlock()
+	}
+}
+
+// exists returns true if the file staged at path was stored or is still staged.
+func (s *staging) exists(path string) (bool, error) {
+	s.mu.Lock()
+	stored := s.stored[path]
+	s.mu.Unlock()
+	if stored {
+		return true, nil
+	}
+	return fileExists(path)
+}
+
+// remove deletes the staging directory.
+func (s *staging) remove() {
+	os.RemoveAll(s.dir)
+}
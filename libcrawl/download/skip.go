@@ -0,0 +1,72 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import (
+	"errors"
+	"io"
+)
+
+// SkipReason explains why a download was deliberately not completed. A skipped download is not a failure.
+type SkipReason int
+
+const (
+	SkipNone SkipReason = iota
+	SkipTooSmall
+	SkipTooLarge
+	SkipContentType
+	SkipExists
+	SkipIdentical
+	SkipDownloaded
+	SkipNotModified
+	SkipDuplicate
+	SkipDimensions
+	SkipExcluded
+)
+
+func (r SkipReason) String() string {
+	switch r {
+	case SkipNone:
+		return "not skipped"
+	case SkipTooSmall:
+		return "smaller than the minimum size"
+	case SkipTooLarge:
+		return "larger than the maximum size"
+	case SkipContentType:
+		return "content type not accepted"
+	case SkipExists:
+		return "file already exists"
+	case SkipIdentical:
+		return "identical file already exists"
+	case SkipDownloaded:
+		return "downloaded by a previous crawl"
+	case SkipNotModified:
+		return "not modified since the previous crawl"
+	case SkipDuplicate:
+		return "duplicate of an earlier download"
+	case SkipDimensions:
+		return "image dimensions not accepted"
+	case SkipExcluded:
+		return "url is excluded"
+	}
+	return "unknown reason"
+}
+
+var errTooLarge = errors.New("maximum size exceeded")
+
+// sizeLimitReader fails with errTooLarge as soon as more than max bytes were read from r.
+type sizeLimitReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (s *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.read += int64(n)
+	if s.read > s.max {
+		return n, errTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,13 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import "syscall"
+
+const xattrSupported = true
+
+// setXattr sets the extended attribute name of the file at path to value.
+func setXattr(path, name, value string) error {
+	return syscall.Setxattr(path, name, []byte(value), 0)
+}
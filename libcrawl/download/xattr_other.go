@@ -0,0 +1,14 @@
+//go:build !linux
+
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package download
+
+import "errors"
+
+const xattrSupported = false
+
+func setXattr(path, name, value string) error {
+	return errors.New("extended attributes are not supported on this platform")
+}
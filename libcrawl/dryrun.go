@@ -0,0 +1,172 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dryRunDownload is a download that would have been dispatched.
+type dryRunDownload struct {
+	Type    string `json:"type"` //always "download"
+	Page    int    `json:"page"`
+	URL     string `json:"url"`
+	File    string `json:"file,omitempty"` //path relative to the output directory, empty if the server names the file
+	Post    string `json:"post,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// dryRunPage is the number of downloads found on a page.
+type dryRunPage struct {
+	Type      string `json:"type"` //always "page"
+	Page      int    `json:"page"`
+	URL       string `json:"url,omitempty"`
+	Downloads int    `json:"downloads"`
+	Posts     int    `json:"posts,omitempty"` //posts crawler only
+}
+
+// dryRunTotal is the summary of a dry run.
+type dryRunTotal struct {
+	Type      string `json:"type"` //always "total"
+	Pages     int    `json:"pages"`
+	Downloads int    `json:"downloads"`
+	Posts     int    `json:"posts,omitempty"`
+}
+
+// dryRun lists the downloads a crawl would dispatch instead of downloading them. The list is printed as text or
+// written as JSON lines.
+type dryRun struct {
+	mu        sync.Mutex
+	output    string //file the JSON lines are written to, "-" for stdout, empty for text on stdout
+	dir       string //output directory, file names are relative to it
+	w         io.Writer
+	f         *os.File
+	enc       *json.Encoder
+	found     map[int]int //downloads by page
+	posts     map[int]int //posts by page, set by the posts crawler instead of downloads
+	pages     int
+	downloads int
+	allPosts  int
+}
+
+func newDryRun(output, dir string) *dryRun {
+	return &dryRun{output: output, dir: dir, w: os.Stdout, found: make(map[int]int), posts: make(map[int]int)}
+}
+
+// open creates the file the JSON lines are written to.
+func (d *dryRun) open() error {
+	if d.output == "" {
+		return nil
+	}
+	if d.output != "-" {
+		f, err := os.Create(d.output)
+		if err != nil {
+			return err
+		}
+		d.f, d.w = f, f
+	}
+	d.enc = json.NewEncoder(d.w)
+	d.enc.SetEscapeHTML(false)
+	return nil
+}
+
+// add lists dl.
+func (d *dryRun) add(dl *download.Download) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.found[dl.Page]++
+	d.downloads++
+	e := &dryRunDownload{Type: "download", Page: dl.Page, URL: dl.Addr.String(), Post: dl.PostID, Reason: dl.Reason}
+	if dl.File() != "" {
+		e.File = dl.Path()
+		if rel, err := filepath.Rel(d.dir, e.File); err == nil {
+			e.File = filepath.ToSlash(rel)
+		}
+	}
+	if dl.Skipped != download.SkipNone {
+		e.Skipped = dl.Skipped.String()
+	}
+	if d.enc != nil {
+		d.write(e)
+		return
+	}
+	file := e.File
+	if file == "" {
+		file = "(named by the server)"
+	}
+	fmt.Fprintf(d.w, "Page %d: %s → %s", e.Page, e.URL, file)
+	if e.Reason != "" {
+		fmt.Fprintf(d.w, " [%s]", e.Reason)
+	}
+	if e.Skipped != "" {
+		fmt.Fprintf(d.w, ", would be skipped (%s)", e.Skipped)
+	}
+	fmt.Fprintln(d.w)
+}
+
+// addPosts notes that n posts were found on page, they are listed by pageDone.
+func (d *dryRun) addPosts(page, n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.posts[page] += n
+	d.allPosts += n
+}
+
+// pageDone lists the number of downloads and posts that were found on page, addr may be nil.
+func (d *dryRun) pageDone(page int, addr *url.URL) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pages++
+	e := &dryRunPage{Type: "page", Page: page, Downloads: d.found[page], Posts: d.posts[page]}
+	if addr != nil {
+		e.URL = addr.String()
+	}
+	_, posts := d.posts[page]
+	delete(d.found, page)
+	delete(d.posts, page)
+	if d.enc != nil {
+		d.write(e)
+	} else if posts && e.URL != "" {
+		//the posts crawler does not download
+		fmt.Fprintf(d.w, "Page %d: %d posts found at %s\n", e.Page, e.Posts, e.URL)
+	} else if posts {
+		fmt.Fprintf(d.w, "Page %d: %d posts found\n", e.Page, e.Posts)
+	} else if e.URL != "" {
+		fmt.Fprintf(d.w, "Page %d: %d downloads found at %s\n", e.Page, e.Downloads, e.URL)
+	} else {
+		fmt.Fprintf(d.w, "Page %d: %d downloads found\n", e.Page, e.Downloads)
+	}
+}
+
+// close lists the totals and closes the JSON lines file.
+func (d *dryRun) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := &dryRunTotal{Type: "total", Pages: d.pages, Downloads: d.downloads, Posts: d.allPosts}
+	if d.enc != nil {
+		d.write(e)
+	} else if e.Posts > 0 {
+		fmt.Fprintf(d.w, "Dry run: %d posts found on %d pages\n", e.Posts, e.Pages)
+	} else {
+		fmt.Fprintf(d.w, "Dry run: %d downloads found on %d pages\n", e.Downloads, e.Pages)
+	}
+	if d.f == nil {
+		return nil
+	}
+	return d.f.Close()
+}
+
+func (d *dryRun) write(v interface{}) {
+	if err := d.enc.Encode(v); err != nil {
+		log.Error(fmt.Errorf("Dry run: %w", err))
+	}
+}
@@ -0,0 +1,116 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testDryRunDownload(t *testing.T, dir, addr, file string) *download.Download {
+	u, err := url.Parse(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := &download.Download{Addr: u, Page: 2, PostID: "11", Reason: "img[src]"}
+	if err := dl.SetDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if file != "" {
+		dl.SetPath(file)
+	}
+	return dl
+}
+
+func TestDryRunJSON(t *testing.T) {
+	dir := t.TempDir()
+	d := newDryRun(filepath.Join(dir, "dry-run.jsonl"), dir)
+	if err := d.open(); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := url.Parse("https://example.net/thread.php?page=2")
+	d.add(testDryRunDownload(t, dir, "https://example.net/a.jpg", "sub/a.jpg"))
+	d.add(testDryRunDownload(t, dir, "https://example.net/b.jpg", ""))
+	d.pageDone(2, page)
+	if err := d.close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "dry-run.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines, got %q", b)
+	}
+	first := new(dryRunDownload)
+	if err := json.Unmarshal(lines[0], first); err != nil {
+		t.Fatal(err)
+	}
+	want := dryRunDownload{Type: "download", Page: 2, URL: "https://example.net/a.jpg", File: "sub/a.jpg", Post: "11", Reason: "img[src]"}
+	if *first != want {
+		t.Errorf("Expected %+v, got %+v", want, *first)
+	}
+	pageEntry := new(dryRunPage)
+	if err := json.Unmarshal(lines[2], pageEntry); err != nil {
+		t.Fatal(err)
+	}
+	if pageEntry.Downloads != 2 || pageEntry.URL != page.String() {
+		t.Errorf("Unexpected page entry %+v", pageEntry)
+	}
+	total := new(dryRunTotal)
+	if err := json.Unmarshal(lines[3], total); err != nil {
+		t.Fatal(err)
+	}
+	if total.Pages != 1 || total.Downloads != 2 {
+		t.Errorf("Unexpected total %+v", total)
+	}
+}
+
+func TestDryRunText(t *testing.T) {
+	dir := t.TempDir()
+	d := newDryRun("", dir)
+	buf := new(bytes.Buffer)
+	d.w = buf
+	d.add(testDryRunDownload(t, dir, "https://example.net/b.jpg", ""))
+	d.pageDone(2, nil)
+	d.close()
+	want := []string{
+		"Page 2: https://example.net/b.jpg → (named by the server) [img[src]]",
+		"Page 2: 1 downloads found",
+		"Dry run: 1 downloads found on 1 pages",
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestDryRunPosts(t *testing.T) {
+	d := newDryRun("", t.TempDir())
+	buf := new(bytes.Buffer)
+	d.w = buf
+	page, _ := url.Parse("https://example.net/thread.php?page=2")
+	d.addPosts(1, 20)
+	d.pageDone(1, nil)
+	d.addPosts(2, 7)
+	d.pageDone(2, page)
+	d.addPosts(3, 0)
+	d.pageDone(3, nil)
+	d.close()
+	want := []string{
+		"Page 1: 20 posts found",
+		"Page 2: 7 posts found at https://example.net/thread.php?page=2",
+		"Page 3: 0 posts found",
+		"Dry run: 27 posts found on 3 pages",
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
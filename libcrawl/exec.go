@@ -0,0 +1,108 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"context"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// DEFAULT_EXEC_JOBS is the default number of hook commands that may run at the same time.
+const DEFAULT_EXEC_JOBS = 1
+
+// maxExecOutput limits the characters of the output of a failed command that is added to the error message.
+const maxExecOutput = 1024
+
+// execHook runs a command for every completed download.
+type execHook struct {
+	args []string      //command and arguments, may contain placeholders
+	sem  chan struct{} //limits the number of concurrent commands
+}
+
+// commandError is the error of a download whose command failed. The file of the download is kept.
+type commandError struct {
+	name string
+	err  error
+}
+
+func (e commandError) Error() string {
+	return fmt.Sprintf("Command %q failed: %s", e.name, e.err)
+}
+
+func (e commandError) Unwrap() error {
+	return e.err
+}
+
+func newExecHook(args []string, jobs int) *execHook {
+	return &execHook{args: args, sem: make(chan struct{}, jobs)}
+}
+
+// execVars returns the placeholders of a command and the names of the environment variables that carry the same values.
+func execVars(dl *download.Download) [][3]string {
+	var pageURL string
+	if dl.PageAddr != nil {
+		pageURL = dl.PageAddr.String()
+	}
+	return [][3]string{
+		{"{path}", "BBCRAWL_PATH", dl.Path()},
+		{"{file}", "BBCRAWL_FILE", dl.File()},
+		{"{url}", "BBCRAWL_URL", dl.Addr.String()},
+		{"{page}", "BBCRAWL_PAGE", strconv.Itoa(dl.Page)},
+		{"{page_url}", "BBCRAWL_PAGE_URL", pageURL},
+		{"{post}", "BBCRAWL_POST", dl.PostID},
+		{"{type}", "BBCRAWL_TYPE", dl.ContentType()},
+	}
+}
+
+// command returns the command for dl with all placeholders replaced.
+func (h *execHook) command(ctx context.Context, dl *download.Download) *exec.Cmd {
+	vars := execVars(dl)
+	pairs := make([]string, 0, 2*len(vars))
+	env := os.Environ()
+	for _, v := range vars {
+		pairs = append(pairs, v[0], v[2])
+		env = append(env, v[1]+"="+v[2])
+	}
+	r := strings.NewReplacer(pairs...)
+	args := make([]string, len(h.args))
+	for i, arg := range h.args {
+		args[i] = r.Replace(arg)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = env
+	return cmd
+}
+
+// afterDownload runs the command for dl and lets the download fail if the command does not succeed. The command is
+// killed when ctx is cancelled. It is meant to be the DownloadDispatcher's hook.
+func (h *execHook) afterDownload(ctx context.Context, dl *download.Download) {
+	select {
+	case h.sem <- struct{}{}:
+	case <-ctx.Done():
+		dl.Err = commandError{name: h.args[0], err: ctx.Err()}
+		return
+	}
+	defer func() { <-h.sem }()
+	cmd := h.command(ctx, dl)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		msg := truncate(strings.TrimSpace(string(out)), maxExecOutput)
+		if msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		dl.Err = commandError{name: cmd.Args[0], err: err}
+		return
+	}
+	if len(out) > 0 {
+		log.Debug(fmt.Sprintf("Command %q: %s", cmd.Args[0], strings.TrimSpace(string(out))))
+	}
+}
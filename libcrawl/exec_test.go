@@ -0,0 +1,54 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"context"
+	"errors"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExecHook(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	addr, _ := url.Parse("https://example.net/attachment.php?id=1&d=2")
+	newDownload := func() *download.Download {
+		dl := &download.Download{Addr: addr, Page: 3, PostID: "post_11"}
+		if err := dl.SetDir(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		dl.SetFile("a b.jpg")
+		return dl
+	}
+	dl := newDownload()
+	hook := newExecHook([]string{"sh", "-c", `test "$BBCRAWL_URL|$BBCRAWL_PATH|$BBCRAWL_PAGE" = "$1"`, "sh", "{url}|{path}|{page}"}, 1)
+	hook.afterDownload(context.Background(), dl)
+	if dl.Err != nil {
+		t.Errorf("Placeholders and environment variables differ: %v", dl.Err)
+	}
+	dl = newDownload()
+	newExecHook([]string{"sh", "-c", "echo broken; exit 3"}, 1).afterDownload(context.Background(), dl)
+	if dl.Err == nil || !strings.Contains(dl.Err.Error(), "exit status 3") || !strings.Contains(dl.Err.Error(), "broken") {
+		t.Errorf("Expected the exit status and output of the failed command, got %v", dl.Err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dl = newDownload()
+	newExecHook([]string{"sleep", "10"}, 1).afterDownload(ctx, dl)
+	if !errors.Is(dl.Err, context.Canceled) {
+		t.Errorf("Expected the command to be cancelled, got %v", dl.Err)
+	}
+	dl = newDownload()
+	hook = newExecHook([]string{"sh", "-c", "printf 'ä%.0s' $(seq 2000); exit 1"}, 1)
+	hook.afterDownload(context.Background(), dl)
+	if dl.Err == nil || !utf8.ValidString(dl.Err.Error()) || !strings.HasSuffix(dl.Err.Error(), "ä…") {
+		t.Errorf("Expected the output to be cut after a whole character, got %v", dl.Err)
+	}
+}
@@ -0,0 +1,219 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+// Package manifest records completed downloads in a JSON lines file, so that later crawls of the same thread
+// can skip files that were already downloaded.
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the manifest file inside of the output directory.
+const FileName = ".bbcrawl-manifest.jsonl"
+
+var errReadOnly = errors.New("manifest was loaded read-only")
+
+// KindPage marks entries that describe a thread page instead of a download.
+const KindPage = "page"
+
+// KindRemoved marks downloads whose file was deleted on purpose, e.g. as a smaller copy of a similar image.
+// Later crawls skip their url although the file is gone.
+const KindRemoved = "removed"
+
+// Entry describes a completed download or, if Kind is KindPage, a thread page whose downloads all completed.
+type Entry struct {
+	Kind         string    `json:"kind,omitempty"`
+	URL          string    `json:"url"`
+	File         string    `json:"file,omitempty"` //path relative to the output directory
+	Size         int64     `json:"size,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Filter       string    `json:"filter,omitempty"` //fingerprint of the crawler and options a page was crawled with
+	Page         int       `json:"page"`
+	Time         time.Time `json:"time"`
+}
+
+// Manifest is an append-only log of entries. If an url occurs more than once, the last entry is valid.
+// A Manifest can be used by multiple goroutines.
+type Manifest struct {
+	mu      *sync.Mutex
+	dir     string
+	f       *os.File //nil if the manifest was loaded read-only
+	entries map[string]*Entry
+	pages   map[string]*Entry
+	cut     int64 //size of the cut-off last line that was removed by Open
+	newline bool  //the file does not end with a line break
+}
+
+// Open loads the manifest of directory dir and opens it for appending. The file is created if it doesn't exist.
+func Open(dir string) (*Manifest, error) {
+	m := newManifest(dir)
+	p := filepath.Join(dir, FileName)
+	if err := m.load(p, true); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m.f = f
+	if m.newline {
+		if _, err := f.Write([]byte{'\n'}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Load loads the manifest of directory dir without changing the file, e.g. for a dry run. A cut-off last line is
+// ignored. Entries cannot be added to the returned manifest. The manifest is empty if the file doesn't exist.
+func Load(dir string) (*Manifest, error) {
+	m := newManifest(dir)
+	if err := m.load(filepath.Join(dir, FileName), false); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newManifest(dir string) *Manifest {
+	return &Manifest{mu: new(sync.Mutex), dir: dir, entries: make(map[string]*Entry), pages: make(map[string]*Entry)}
+}
+
+// Truncated returns the size of the cut-off last line that Open removed from the manifest file, 0 if there was none.
+func (m *Manifest) Truncated() int64 {
+	return m.cut
+}
+
+// load reads the entries of the manifest file at p. A last line without a line break was cut off by an interrupted
+// crawl, it is removed from the file if it is not a valid entry and repair is true. Invalid lines elsewhere are an
+// error.
+func (m *Manifest) load(p string, repair bool) error {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var end int64 //offset behind the last valid line
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		complete := err == nil
+		if len(bytes.TrimSpace(b)) > 0 {
+			e := new(Entry)
+			if jerr := json.Unmarshal(b, e); jerr != nil {
+				if complete {
+					return fmt.Errorf("%s, line %d: %w", p, line, jerr)
+				}
+				if !repair {
+					return nil
+				}
+				m.cut = int64(len(b))
+				return os.Truncate(p, end)
+			}
+			m.index(e)
+			if !complete {
+				//the next entry must start on a line of its own
+				m.newline = true
+			}
+		}
+		end += int64(len(b))
+		if !complete {
+			return nil
+		}
+	}
+}
+
+// Lookup returns the entry of url or nil if the url is unknown.
+func (m *Manifest) Lookup(url string) *Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.entries[url]
+}
+
+// Exists returns true if the file of entry e is still present in the manifest's directory.
+func (m *Manifest) Exists(e *Entry) bool {
+	_, err := os.Stat(filepath.Join(m.dir, e.File))
+	return err == nil
+}
+
+// Add appends entry e to the manifest. Page entries must have their Kind set to KindPage.
+func (m *Manifest) Add(e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.f == nil {
+		return errReadOnly
+	}
+	if _, err := m.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	m.index(e)
+	return nil
+}
+
+func (m *Manifest) index(e *Entry) {
+	if e.Kind == KindPage {
+		m.pages[pageKey(e.URL, e.Filter)] = e
+	} else {
+		m.entries[e.URL] = e
+	}
+}
+
+// Files returns the download entries of the manifest whose file was not removed.
+func (m *Manifest) Files() []*Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := make([]*Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		if e.Kind != KindRemoved {
+			files = append(files, e)
+		}
+	}
+	return files
+}
+
+// LookupPage returns the page entry of url that was recorded with filter or nil if the page is unknown. Crawls with
+// a different crawler or different options may have skipped downloads of the page, so their entries don't count.
+func (m *Manifest) LookupPage(url, filter string) *Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pages[pageKey(url, filter)]
+}
+
+func pageKey(url, filter string) string {
+	return filter + " " + url
+}
+
+// Entries returns the number of download urls known to the manifest.
+func (m *Manifest) Entries() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+func (m *Manifest) Close() error {
+	if m.f == nil {
+		return nil
+	}
+	return m.f.Close()
+}
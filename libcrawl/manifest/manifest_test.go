@@ -0,0 +1,146 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &Entry{URL: "https://example.net/a.jpg", File: "1-a.jpg", Size: 3, Page: 1}
+	second := &Entry{URL: "https://example.net/b.jpg", File: "1-b.jpg", Size: 5, Page: 1}
+	updated := &Entry{URL: "https://example.net/a.jpg", File: "2-a.jpg", Size: 4, Page: 2}
+	page := &Entry{Kind: KindPage, URL: "https://example.net/a.jpg", ETag: `"abc"`, Filter: "src", Page: 1}
+	other := &Entry{Kind: KindPage, URL: page.URL, ETag: `"def"`, Filter: "posts", Page: 1}
+	for _, e := range []*Entry{first, second, updated, page, other} {
+		if err := m.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.Entries() != 2 {
+		t.Errorf("Expected 2 entries, got %d", m.Entries())
+	}
+	e := m.Lookup(first.URL)
+	if e == nil || e.File != updated.File || e.Size != updated.Size {
+		t.Errorf("Expected the last entry for %q to be valid, got %+v", first.URL, e)
+	}
+	for _, want := range []*Entry{page, other} {
+		if p := m.LookupPage(want.URL, want.Filter); p == nil || p.ETag != want.ETag {
+			t.Errorf("Expected page entry %+v, got %+v", want, p)
+		}
+	}
+	if p := m.LookupPage(page.URL, ""); p != nil {
+		t.Errorf("Expected no page entry without a filter, got %+v", p)
+	}
+	if m.Lookup("https://example.net/unknown.jpg") != nil {
+		t.Error("Expected nil for an unknown url")
+	}
+	if m.Exists(e) {
+		t.Errorf("File %q should not exist", e.File)
+	}
+	if err := os.WriteFile(filepath.Join(dir, e.File), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Exists(e) {
+		t.Errorf("File %q should exist", e.File)
+	}
+}
+
+func TestManifestCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte("{\"url\":\"x\"}\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Error("Expected an error for a corrupt manifest")
+	}
+}
+
+func TestManifestTruncated(t *testing.T) {
+	var tests = []struct {
+		content string
+		cut     int64
+		urls    int
+	}{
+		{"{\"url\":\"a\"}\n{\"url\":\"b\",\"fi", 14, 1},
+		{"{\"url\":\"a\"}\n{\"url\":\"b\"}", 0, 2},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		p := filepath.Join(dir, FileName)
+		if err := os.WriteFile(p, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		m, err := Open(dir)
+		if err != nil {
+			t.Fatalf("%q: %v", test.content, err)
+		}
+		if m.Truncated() != test.cut || m.Entries() != test.urls {
+			t.Errorf("%q: expected %d cut bytes and %d urls, got %d and %d", test.content, test.cut, test.urls, m.Truncated(), m.Entries())
+		}
+		//new entries start on a line of their own
+		if err := m.Add(&Entry{URL: "c"}); err != nil {
+			t.Fatal(err)
+		}
+		m.Close()
+		m, err = Open(dir)
+		if err != nil {
+			t.Fatalf("%q: reopening failed: %v", test.content, err)
+		}
+		if m.Lookup("c") == nil || m.Entries() != test.urls+1 {
+			t.Errorf("%q: the new entry was not read back", test.content)
+		}
+		m.Close()
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, content := range []string{"{\"url\":\"a\"}\n{\"url\":\"b\",\"fi", "{\"url\":\"a\"}"} {
+		dir := t.TempDir()
+		p := filepath.Join(dir, FileName)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		m, err := Load(dir)
+		if err != nil {
+			t.Fatalf("%q: %v", content, err)
+		}
+		if m.Lookup("a") == nil || m.Entries() != 1 || m.Truncated() != 0 {
+			t.Errorf("%q: unexpected entries %d, %d cut bytes", content, m.Entries(), m.Truncated())
+		}
+		if err := m.Add(&Entry{URL: "c"}); err == nil {
+			t.Errorf("%q: an entry was added to a read-only manifest", content)
+		}
+		if err := m.Close(); err != nil {
+			t.Error(err)
+		}
+		if b, err := os.ReadFile(p); err != nil || string(b) != content {
+			t.Errorf("%q: the file was changed to %q, %v", content, b, err)
+		}
+	}
+	//a missing file is not created
+	dir := t.TempDir()
+	if m, err := Load(dir); err != nil || m.Entries() != 0 {
+		t.Errorf("Unexpected result for a missing manifest: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, FileName)); !os.IsNotExist(err) {
+		t.Errorf("The manifest file was created: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nameVariables lists the variables a name template can use.
+var nameVariables = map[string]bool{
+	"page":   true, //page number
+	"post":   true, //post id, empty if the crawler doesn't know about posts
+	"index":  true, //counts the downloads of a page or post starting with 1, what counts depends on the crawler
+	"name":   true, //file name without extension
+	"ext":    true, //file extension without the leading dot
+	"host":   true, //host of the download url
+	"thread": true, //thread identifier derived from the pager's url
+	"date":   true, //date the crawl was started on, YYYY-MM-DD
+	"hash":   true, //first 16 hex digits of the SHA-256 of the download url, not of the content
+}
+
+// templatePart is either a literal string or a variable that is optionally padded with zeroes to width characters.
+type templatePart struct {
+	literal  string
+	variable string
+	width    int
+}
+
+// nameTemplate generates download paths like "{thread}/{page:04}/{post}-{index}.{ext}". A "/" creates a subdirectory,
+// "{{" and "}}" produce literal braces.
+type nameTemplate struct {
+	raw   string
+	parts []templatePart
+}
+
+func parseNameTemplate(s string) (*nameTemplate, error) {
+	t := &nameTemplate{raw: s, parts: make([]templatePart, 0, 10)}
+	literal := new(strings.Builder)
+	flush := func() {
+		if literal.Len() > 0 {
+			t.parts = append(t.parts, templatePart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			if i+1 < len(s) && s[i+1] == '{' {
+				literal.WriteByte('{')
+				i++
+				continue
+			}
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("Name template %q: unclosed \"{\"", s)
+			}
+			part, err := parseTemplateVariable(s[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("Name template %q: %w", s, err)
+			}
+			flush()
+			t.parts = append(t.parts, part)
+			i += end
+		case '}':
+			if i+1 < len(s) && s[i+1] == '}' {
+				literal.WriteByte('}')
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("Name template %q: unexpected \"}\"", s)
+		default:
+			literal.WriteByte(s[i])
+		}
+	}
+	flush()
+	if len(t.parts) == 0 {
+		return nil, fmt.Errorf("Name template is empty")
+	}
+	return t, nil
+}
+
+// parseTemplateVariable parses the content of a variable like "page" or "page:04".
+func parseTemplateVariable(s string) (templatePart, error) {
+	name, format := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, format = s[:i], s[i+1:]
+	}
+	if !nameVariables[name] {
+		return templatePart{}, fmt.Errorf("unknown variable %q", name)
+	}
+	part := templatePart{variable: name}
+	if format != "" {
+		width, err := strconv.Atoi(format)
+		if err != nil || format[0] != '0' || width < 1 {
+			return templatePart{}, fmt.Errorf("variable %q: invalid format %q, expected a width like \"04\"", name, format)
+		}
+		part.width = width
+	}
+	return part, nil
+}
+
+func (t *nameTemplate) String() string {
+	return t.raw
+}
+
+// nameVars contains the values of a name template's variables for a single download.
+type nameVars struct {
+	page   int
+	post   string
+	index  int
+	file   string //file name including extension
+	url    *url.URL
+	thread string
+	date   time.Time
+}
+
+func (v *nameVars) value(variable string) string {
+	switch variable {
+	case "page":
+		return strconv.Itoa(v.page)
+	case "post":
+		return v.post
+	case "index":
+		return strconv.Itoa(v.index)
+	case "name":
+		return strings.TrimSuffix(v.file, path.Ext(v.file))
+	case "ext":
+		return strings.TrimPrefix(path.Ext(v.file), ".")
+	case "host":
+		if v.url == nil {
+			return ""
+		}
+		return v.url.Hostname()
+	case "thread":
+		return v.thread
+	case "date":
+		return v.date.Format("2006-01-02")
+	case "hash":
+		if v.url == nil {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(v.url.String()))
+		return hex.EncodeToString(sum[:8])
+	}
+	panic(fmt.Errorf("unknown template variable %q", variable))
+}
+
+// expand returns the "/"-separated relative path for a download. Slashes inside of variable values are replaced by "_",
+// so only the template itself creates subdirectories. A dot in front of an empty {ext} is dropped.
+func (t *nameTemplate) expand(v *nameVars) string {
+	b := new(strings.Builder)
+	for i, part := range t.parts {
+		if part.variable == "" {
+			lit := part.literal
+			if i+1 < len(t.parts) && t.parts[i+1].variable == "ext" && v.value("ext") == "" {
+				lit = strings.TrimSuffix(lit, ".")
+			}
+			b.WriteString(lit)
+			continue
+		}
+		val := strings.ReplaceAll(v.value(part.variable), "/", "_")
+		for pad := part.width - len(val); pad > 0; pad-- {
+			b.WriteByte('0')
+		}
+		b.WriteString(val)
+	}
+	return b.String()
+}
+
+// escapeTemplate doubles the braces of s, so that it becomes a literal part of a name template.
+func escapeTemplate(s string) string {
+	return strings.NewReplacer("{", "{{", "}", "}}").Replace(s)
+}
+
+// partial returns a template that contains the values of all variables but {name} and {ext}. It renames a download
+// like t once the name of its file is known.
+func (t *nameTemplate) partial(v *nameVars) string {
+	b := new(strings.Builder)
+	for _, part := range t.parts {
+		switch {
+		case part.variable == "":
+			b.WriteString(escapeTemplate(part.literal))
+		case part.variable == "name" || part.variable == "ext":
+			b.WriteString("{" + part.variable + "}")
+		default:
+			val := strings.ReplaceAll(v.value(part.variable), "/", "_")
+			for pad := part.width - len(val); pad > 0; pad-- {
+				b.WriteByte('0')
+			}
+			b.WriteString(escapeTemplate(val))
+		}
+	}
+	return b.String()
+}
+
+// threadName derives a thread identifier from the pager's url. It is the last path segment without extension.
+// A query parameter that commonly identifies threads is appended, e.g. "showthread-1234" for "showthread.php?t=1234".
+func threadName(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	name := strings.TrimSuffix(path.Base(strings.TrimRight(u.Path, "/")), path.Ext(u.Path))
+	if name == "." || name == "/" {
+		name = ""
+	}
+	query := u.Query()
+	for _, key := range []string{"t", "threadid", "thread", "topic", "tid"} {
+		if id := query.Get(key); id != "" {
+			if name == "" {
+				return id
+			}
+			return name + "-" + id
+		}
+	}
+	if name == "" {
+		return u.Hostname()
+	}
+	return name
+}
@@ -0,0 +1,94 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNameTemplate(t *testing.T) {
+	u, _ := url.Parse("https://img.example.net/files/holiday.jpeg?size=large")
+	vars := &nameVars{
+		page:   7,
+		post:   "4711",
+		index:  2,
+		file:   "holiday.jpeg",
+		url:    u,
+		thread: "1234-some-thread",
+		date:   time.Date(2020, 5, 17, 12, 0, 0, 0, time.UTC),
+	}
+	var tests = map[string]string{
+		"{thread}/{page:04}/{post}-{index}.{ext}": "1234-some-thread/0007/4711-2.jpeg",
+		"{date}_{host}_{name}":                    "2020-05-17_img.example.net_holiday",
+		"{{literal}} {page:01}":                   "{literal} 7",
+	}
+	for input, want := range tests {
+		tmpl, err := parseNameTemplate(input)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if got := tmpl.expand(vars); got != want {
+			t.Errorf("%q: expected %q, got %q", input, want, got)
+		}
+	}
+	tmpl, _ := parseNameTemplate("{hash}")
+	if got := tmpl.expand(vars); len(got) != 16 {
+		t.Errorf("{hash}: expected 16 hex digits, got %q", got)
+	}
+	noext := &nameVars{file: "attachment", index: 1}
+	tmpl, _ = parseNameTemplate("{name}-{index}.{ext}")
+	if got := tmpl.expand(noext); got != "attachment-1" {
+		t.Errorf("Expected the dot to be dropped for an empty extension, got %q", got)
+	}
+	slash := &nameVars{post: "a/b"}
+	tmpl, _ = parseNameTemplate("{post}")
+	if got := tmpl.expand(slash); got != "a_b" {
+		t.Errorf("Expected slashes in values to be replaced, got %q", got)
+	}
+}
+
+func TestNameTemplatePartial(t *testing.T) {
+	vars := &nameVars{page: 7, post: "4711", file: "holiday.jpeg", thread: "{odd}"}
+	tmpl, err := parseNameTemplate("{{x}} {thread}/{page:04}/{post}-{name}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := tmpl.partial(vars)
+	if want := "{{x}} {{odd}}/0007/4711-{name}.{ext}"; partial != want {
+		t.Errorf("Expected %q, got %q", want, partial)
+	}
+	rest, err := parseNameTemplate(partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rest.expand(&nameVars{file: vars.file}), tmpl.expand(vars); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestNameTemplateErrors(t *testing.T) {
+	for _, input := range []string{"", "{page", "page}", "{unknown}", "{page:4}", "{page:0x}", "{page:00}"} {
+		if _, err := parseNameTemplate(input); err == nil {
+			t.Errorf("%q should have caused an error", input)
+		}
+	}
+}
+
+func TestThreadName(t *testing.T) {
+	var tests = map[string]string{
+		"https://forum.example.net/threads/1234-some-thread/":    "1234-some-thread",
+		"https://forum.example.net/showthread.php?t=1234&page=2": "showthread-1234",
+		"https://forum.example.net/viewtopic.php?f=2&t=99":       "viewtopic-99",
+		"https://forum.example.net/":                             "forum.example.net",
+	}
+	for input, want := range tests {
+		u, _ := url.Parse(input)
+		if got := threadName(u); got != want {
+			t.Errorf("%q: expected %q, got %q", input, want, got)
+		}
+	}
+}
@@ -51,6 +51,18 @@ func (r *QueryPager) PageNum() int {
 	return r.counter.val - 1
 }
 
+func (r *QueryPager) position() int {
+	return r.counter.val
+}
+
+func (r *QueryPager) seek(pos int) {
+	r.counter.val = pos
+}
+
+func (r *QueryPager) lastPage() int {
+	return r.counter.limit
+}
+
 func (r *QueryPager) SetOptions(args []string) error {
 	start := cmdline.StartPage(0)
 	end := cmdline.NewEndPage(&start)
@@ -134,6 +146,20 @@ func (r *URLCuttingPager) PageNum() int {
 	return r.page - 1 + r.adjust
 }
 
+// position ignores the start page, it is never yielded again once a checkpoint was written.
+func (r *URLCuttingPager) position() int {
+	return r.page
+}
+
+func (r *URLCuttingPager) seek(pos int) {
+	r.startpage = nil
+	r.page = pos
+}
+
+func (r *URLCuttingPager) lastPage() int {
+	return r.end + r.adjust
+}
+
 func (r *URLCuttingPager) SetOptions(args []string) error {
 	var cut = new(cmdline.IntTuple)
 	//setup
@@ -251,6 +277,18 @@ func (r *VB4Pager) PageNum() int {
 	return r.page - 1
 }
 
+func (r *VB4Pager) position() int {
+	return r.page
+}
+
+func (r *VB4Pager) seek(pos int) {
+	r.page = pos
+}
+
+func (r *VB4Pager) lastPage() int {
+	return r.End
+}
+
 func (r *VB4Pager) SetOptions(args []string) error {
 	start := cmdline.StartPage(0)
 	end := cmdline.NewEndPage(&start)
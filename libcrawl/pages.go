@@ -0,0 +1,88 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// pageState follows a page from its request until all of its downloads were collected.
+type pageState struct {
+	addr         *url.URL //nil if the page was not requested by getPage
+	etag         string
+	lastModified string
+	crawled      bool //the crawler is done with the page
+	outstanding  int  //downloads that were dispatched but not yet collected
+	failed       int  //downloads that failed
+}
+
+// pageTracker keeps the state of every page that is not complete yet. A page is complete once it was crawled and
+// all of its downloads were collected, complete is then called exactly once for the page.
+type pageTracker struct {
+	mu       sync.Mutex
+	pages    map[int]*pageState
+	complete func(page int, st *pageState)
+}
+
+func newPageTracker(complete func(int, *pageState)) *pageTracker {
+	return &pageTracker{pages: make(map[int]*pageState), complete: complete}
+}
+
+// state returns the state of page, it must be called with t.mu held.
+func (t *pageTracker) state(page int) *pageState {
+	st := t.pages[page]
+	if st == nil {
+		st = new(pageState)
+		t.pages[page] = st
+	}
+	return st
+}
+
+// update calls f with the state of page and checks afterwards whether the page is complete.
+func (t *pageTracker) update(page int, f func(st *pageState)) {
+	t.mu.Lock()
+	st := t.state(page)
+	f(st)
+	done := st.crawled && st.outstanding == 0
+	if done {
+		delete(t.pages, page)
+	}
+	t.mu.Unlock()
+	if done && t.complete != nil {
+		t.complete(page, st)
+	}
+}
+
+// fetched notes the address and the validators of a page that was received from the server.
+func (t *pageTracker) fetched(page int, u *url.URL, header http.Header) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.state(page)
+	st.addr = u
+	st.etag = header.Get("ETag")
+	st.lastModified = header.Get("Last-Modified")
+}
+
+func (t *pageTracker) dispatched(page int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state(page).outstanding++
+}
+
+func (t *pageTracker) collected(page int, failed bool) {
+	t.update(page, func(st *pageState) {
+		st.outstanding--
+		if failed {
+			st.failed++
+		}
+	})
+}
+
+func (t *pageTracker) crawled(page int) {
+	t.update(page, func(st *pageState) {
+		st.crawled = true
+	})
+}
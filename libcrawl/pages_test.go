@@ -0,0 +1,33 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"testing"
+)
+
+func TestPageTracker(t *testing.T) {
+	complete := make(map[int]int)
+	tracker := newPageTracker(func(page int, st *pageState) {
+		complete[page] = st.failed
+	})
+	tracker.dispatched(1)
+	tracker.dispatched(1)
+	tracker.collected(1, true)
+	tracker.crawled(1)
+	if _, ok := complete[1]; ok {
+		t.Fatal("Page 1 completed with an outstanding download")
+	}
+	tracker.collected(1, false)
+	if failed, ok := complete[1]; !ok || failed != 1 {
+		t.Errorf("Expected page 1 to complete with 1 failed download, got %v, %d", ok, failed)
+	}
+	tracker.crawled(2)
+	if failed, ok := complete[2]; !ok || failed != 0 {
+		t.Errorf("Expected page 2 to complete without downloads, got %v, %d", ok, failed)
+	}
+	if len(tracker.pages) != 0 {
+		t.Errorf("Expected no pending pages, got %d", len(tracker.pages))
+	}
+}
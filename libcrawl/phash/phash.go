@@ -0,0 +1,113 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+// Package phash computes perceptual hashes of images and groups images whose hashes are similar.
+// Unlike a cryptographic hash, a perceptual hash changes only slightly if an image is resized or re-compressed.
+package phash
+
+import (
+	"image"
+	"math/bits"
+)
+
+// Hash is a 64 bit perceptual hash.
+type Hash uint64
+
+// Distance returns the number of bits that differ between h and o. Images with a distance of up to 10
+// usually look alike.
+func (h Hash) Distance(o Hash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+// DHash computes the difference hash of img: The image is reduced to 9x8 gray pixels, every bit of the hash
+// tells whether a pixel is brighter than its right neighbour.
+func DHash(img image.Image) Hash {
+	const w, h = 9, 8
+	gray := shrink(img, w, h)
+	var hash Hash
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if gray[y*w+x] > gray[y*w+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// shrink returns the luminance of img scaled down to w×h pixels, each pixel is the average of the area it covers.
+func shrink(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	gray := make([]float64, w*h)
+	for ty := 0; ty < h; ty++ {
+		y0, y1 := span(b.Min.Y, b.Dy(), ty, h)
+		for tx := 0; tx < w; tx++ {
+			x0, x1 := span(b.Min.X, b.Dx(), tx, w)
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			gray[ty*w+tx] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return gray
+}
+
+// span returns the source coordinates [from, to) that are covered by target pixel i of n, to > from is guaranteed.
+func span(min, length, i, n int) (int, int) {
+	from := min + i*length/n
+	to := min + (i+1)*length/n
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+// Groups returns the indices of hashes that are similar to each other, every group has at least 2 members.
+// Two hashes are similar if their distance is at most maxDistance, similarity is transitive.
+// The groups and their members are ordered by their first index.
+func Groups(hashes []Hash, maxDistance int) [][]int {
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if hashes[i].Distance(hashes[j]) <= maxDistance {
+				a, b := find(i), find(j)
+				if a < b {
+					parent[b] = a
+				} else if b < a {
+					parent[a] = b
+				}
+			}
+		}
+	}
+	members := make(map[int][]int)
+	roots := make([]int, 0)
+	for i := range hashes {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+	groups := make([][]int, 0)
+	for _, root := range roots {
+		if len(members[root]) > 1 {
+			groups = append(groups, members[root])
+		}
+	}
+	return groups
+}
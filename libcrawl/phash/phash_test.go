@@ -0,0 +1,47 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package phash
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// testImage returns a w×h image with a diagonal gradient, inverted if invert is true.
+func testImage(w, h int, invert bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*x + y*3) * 255 / (w*w + h*3))
+			if invert {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	large := DHash(testImage(640, 480, false))
+	small := DHash(testImage(160, 120, false))
+	inverted := DHash(testImage(640, 480, true))
+	if d := large.Distance(small); d > 4 {
+		t.Errorf("Expected a resized image to be similar, got distance %d", d)
+	}
+	if d := large.Distance(inverted); d < 32 {
+		t.Errorf("Expected an inverted image to differ, got distance %d", d)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	hashes := []Hash{0x0, 0xff00, 0x1, 0xff01, 0xf0f0f0f0, 0x3}
+	got := Groups(hashes, 1)
+	want := [][]int{{0, 2, 5}, {1, 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,110 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"github.com/jwdev42/bbcrawl/libhtml"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var phpbbPostID = regexp.MustCompile(`^p[0-9]+$`)
+
+// phpbbPostFragment matches the fragment of a link to a post.
+var phpbbPostFragment = regexp.MustCompile(`^p([0-9]+)$`)
+
+// phpbbDateLayouts are the date formats of phpBB that are understood, phpBB 3.2 and later state the date in ISO 8601.
+var phpbbDateLayouts = []string{
+	"Mon Jan 02, 2006 3:04 pm",
+	"Mon Jan 02, 2006 15:04",
+	"02 Jan 2006, 15:04",
+}
+
+// phpbbPost is a post of phpBB 3.
+type phpbbPost html.Node
+
+// phpbbThreadPosts returns the posts of a page of phpBB 3.
+func phpbbThreadPosts(document *html.Node, page *url.URL, now time.Time) ([]*threadPost, error) {
+	posts := make([]*threadPost, 0)
+	for _, n := range libhtml.ElementsByAttrMatch(document, "id", phpbbPostID) {
+		if !libhtml.HasClass(n, "post") {
+			continue
+		}
+		post, err := (*phpbbPost)(n).threadPost(page)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+func (r *phpbbPost) id() string {
+	return strings.TrimPrefix(libhtml.AttrVal((*html.Node)(r), "id"), "p")
+}
+
+// threadPost returns the post as it is written by the PostsCrawler.
+func (r *phpbbPost) threadPost(page *url.URL) (*threadPost, error) {
+	node := (*html.Node)(r)
+	post := &threadPost{ID: r.id(), Permalink: r.permalink(page)}
+	//the profile next to the post and the line above of it link to the author, guests have no link
+	if elem := libhtml.ElementByClass(node, "username", "username-coloured"); elem != nil {
+		post.Author = libhtml.Text(elem)
+		post.AuthorID = memberID(libhtml.AttrVal(elem, "href"))
+	}
+	r.date(post)
+	if content := libhtml.ElementByClass(node, "content"); content != nil {
+		var err error
+		if post.HTML, err = libhtml.InnerHTML(content); err != nil {
+			return nil, err
+		}
+		post.Text = libhtml.Text(content)
+		post.Quotes = linkedPosts(content, phpbbPostFragment)
+	}
+	post.Attachments = attachmentLinks(node, page, func(u *url.URL) string {
+		if path.Base(u.Path) != "file.php" {
+			return ""
+		}
+		//avatars are served by file.php as well, but without an id
+		return u.Query().Get("id")
+	})
+	return post, nil
+}
+
+// date sets the date of post from the line above of the post. phpBB 3.2 and later put it into a time element,
+// older versions write it behind a "»".
+func (r *phpbbPost) date(post *threadPost) {
+	author := libhtml.ElementByClass((*html.Node)(r), "author")
+	if author == nil {
+		return
+	}
+	if times := libhtml.ElementsByTag(author, atom.Time); len(times) > 0 {
+		post.Date = libhtml.Text(times[0])
+		if t, err := time.Parse(time.RFC3339, libhtml.AttrVal(times[0], "datetime")); err == nil {
+			post.Timestamp = t.Format(time.RFC3339)
+			return
+		}
+	} else if text := libhtml.Text(author); strings.Contains(text, "»") {
+		post.Date = strings.TrimSpace(text[strings.LastIndex(text, "»")+len("»"):])
+	}
+	if t, ok := parseDate(post.Date, phpbbDateLayouts); ok {
+		post.Timestamp = t.Format("2006-01-02T15:04:05")
+	}
+}
+
+// permalink returns the address of the post, taken from the first link that passes the post's ID as parameter "p".
+// The post's anchor on page is used if there is none.
+func (r *phpbbPost) permalink(page *url.URL) string {
+	for _, a := range libhtml.ElementsByTag((*html.Node)(r), atom.A) {
+		if u, err := url.Parse(libhtml.AttrVal(a, "href")); err == nil && u.Query().Get("p") == r.id() {
+			return page.ResolveReference(u).String()
+		}
+	}
+	return postAnchor(page, "p"+r.id())
+}
@@ -0,0 +1,295 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Modes of the progress display.
+const (
+	PROGRESS_AUTO = "auto" //show the progress if stdout is a terminal
+	PROGRESS_ON   = "on"
+	PROGRESS_OFF  = "off"
+)
+
+// progressInterval is the time between two updates of the progress display.
+const progressInterval = 250 * time.Millisecond
+
+// progressMaxActive is the maximum number of running downloads that are shown with their own line.
+const progressMaxActive = 8
+
+// lastPager is implemented by pagers that know the number of their last page.
+type lastPager interface {
+	lastPage() int
+}
+
+// activeDownload is a download that is receiving its content.
+type activeDownload struct {
+	name     string
+	size     int64 //-1 if unknown
+	received int64
+	started  time.Time
+	seq      int //start order
+}
+
+// progressDisplay shows the state of a crawl in the last lines of the terminal: the current page, the running
+// downloads with their received bytes, size and speed, the number of queued downloads and the totals. It is redrawn
+// periodically and every time a log message is printed, log messages are printed above of it.
+type progressDisplay struct {
+	mu        sync.Mutex
+	w         io.Writer
+	width     int
+	lines     int //number of lines that were drawn last
+	started   time.Time
+	page      int
+	lastPage  int //0 if unknown
+	queued    int
+	active    map[*download.Download]*activeDownload
+	startSeq  int
+	finished  int
+	failed    int
+	received  int64
+	stop      chan struct{}
+	done      chan struct{}
+	logOutput io.Writer //output of the logger while the display is not running
+}
+
+// parseProgressMode returns whether the progress display is shown for mode.
+func parseProgressMode(mode string) (bool, error) {
+	switch mode {
+	case PROGRESS_AUTO:
+		return isTerminal(os.Stdout), nil
+	case PROGRESS_ON:
+		return true, nil
+	case PROGRESS_OFF:
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown progress mode %q", mode)
+}
+
+// isTerminal returns true if f is a character device, i.e. a terminal. A terminal without cursor control is ignored.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	return os.Getenv("TERM") != "dumb"
+}
+
+// terminalWidth returns the width of the terminal from the environment variable COLUMNS, default is 80.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 20 {
+		return n
+	}
+	return 80
+}
+
+func newProgressDisplay(w io.Writer) *progressDisplay {
+	return &progressDisplay{
+		w:      w,
+		width:  terminalWidth(),
+		active: make(map[*download.Download]*activeDownload),
+	}
+}
+
+// start redirects the log messages through the display and starts to redraw it periodically.
+func (p *progressDisplay) start(pager PagerInterface) {
+	if lp, ok := pager.(lastPager); ok {
+		p.lastPage = lp.lastPage()
+	}
+	p.started = time.Now()
+	p.stop, p.done = make(chan struct{}), make(chan struct{})
+	p.logOutput = os.Stdout
+	log.SetOutput(p)
+	go func() {
+		defer close(p.done)
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.mu.Lock()
+				p.redraw()
+				p.mu.Unlock()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close removes the display from the terminal and restores the output of the logger.
+func (p *progressDisplay) close() {
+	close(p.stop)
+	<-p.done
+	//the logger holds its lock while it writes to the display, so it must not be called with p.mu held
+	log.SetOutput(p.logOutput)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+// Write prints a log message above of the display.
+func (p *progressDisplay) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.w.Write(b)
+	p.draw()
+	return n, err
+}
+
+// pageStarted notes that the crawler started to work on page.
+func (p *progressDisplay) pageStarted(page int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.page = page
+}
+
+func (p *progressDisplay) Queued(dl *download.Download) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queued++
+}
+
+func (p *progressDisplay) Started(dl *download.Download, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queued--
+	p.startSeq++
+	p.active[dl] = &activeDownload{name: progressName(dl), size: size, started: time.Now(), seq: p.startSeq}
+}
+
+func (p *progressDisplay) Transferred(dl *download.Download, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if a := p.active[dl]; a != nil {
+		a.received += n
+	}
+	p.received += n
+}
+
+func (p *progressDisplay) Finished(dl *download.Download) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.active[dl]; ok {
+		delete(p.active, dl)
+	} else {
+		p.queued--
+	}
+	p.finished++
+	if dl.Err != nil {
+		p.failed++
+	}
+}
+
+// progressName returns the name a download is shown with.
+func progressName(dl *download.Download) string {
+	if dl.Named() {
+		return dl.File()
+	}
+	if name := download.FileNameFromURL(dl.Addr); name != "" {
+		return name
+	}
+	return dl.Addr.String()
+}
+
+// clear removes the display from the terminal, it must be called with p.mu held.
+func (p *progressDisplay) clear() {
+	if p.lines > 0 {
+		//move the cursor to the beginning of the first line of the display and erase everything below of it
+		fmt.Fprintf(p.w, "\x1b[%dF\x1b[J", p.lines)
+		p.lines = 0
+	}
+}
+
+// redraw replaces the display, it must be called with p.mu held.
+func (p *progressDisplay) redraw() {
+	p.clear()
+	p.draw()
+}
+
+// draw writes the display below of the cursor, it must be called with p.mu held.
+func (p *progressDisplay) draw() {
+	lines := p.render(time.Now())
+	for _, line := range lines {
+		fmt.Fprintln(p.w, line)
+	}
+	p.lines = len(lines)
+}
+
+// render returns the lines of the display at time now.
+func (p *progressDisplay) render(now time.Time) []string {
+	elapsed := now.Sub(p.started)
+	page := "-"
+	if p.page > 0 {
+		page = strconv.Itoa(p.page)
+	}
+	if p.lastPage > 0 {
+		page += "/" + strconv.Itoa(p.lastPage)
+	}
+	summary := fmt.Sprintf("Page %s | %d running, %d queued | %d done, %d failed | %s in %s, %s/s",
+		page, len(p.active), p.queued, p.finished, p.failed, formatBytes(p.received),
+		elapsed.Round(time.Second), formatBytes(speed(p.received, elapsed)))
+	lines := []string{p.fit(summary)}
+	active := make([]*activeDownload, 0, len(p.active))
+	for _, a := range p.active {
+		active = append(active, a)
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].seq < active[j].seq })
+	for i, a := range active {
+		if i == progressMaxActive {
+			lines = append(lines, fmt.Sprintf("  … %d more", len(active)-i))
+			break
+		}
+		var state string
+		if a.size >= 0 {
+			percent := 100
+			if a.size > 0 {
+				percent = int(a.received * 100 / a.size)
+			}
+			state = fmt.Sprintf("%s / %s %3d%%", formatBytes(a.received), formatBytes(a.size), percent)
+		} else {
+			state = formatBytes(a.received)
+		}
+		state += fmt.Sprintf(" %s/s", formatBytes(speed(a.received, now.Sub(a.started))))
+		name := truncate(a.name, p.width-utf8.RuneCountInString(state)-5)
+		lines = append(lines, "  "+name+"  "+state)
+	}
+	return lines
+}
+
+// fit shortens line to the width of the terminal, the last column is left blank to prevent line wraps.
+func (p *progressDisplay) fit(line string) string {
+	return truncate(line, p.width-1)
+}
+
+// speed returns the number of bytes per second.
+func speed(n int64, d time.Duration) int64 {
+	if d < time.Second {
+		d = time.Second
+	}
+	return int64(float64(n) / d.Seconds())
+}
+
+// truncate shortens s to at most n runes, an ellipsis marks the cut.
+func truncate(s string, n int) string {
+	if n < 1 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,57 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"bytes"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressDisplay(t *testing.T) {
+	out := new(bytes.Buffer)
+	p := newProgressDisplay(out)
+	p.width = 90
+	p.started = time.Now().Add(-2 * time.Second)
+	p.page, p.lastPage = 3, 10
+	dls := make([]*download.Download, 3)
+	for i, name := range []string{"a.jpg", strings.Repeat("long-", 20) + ".jpg", "c.jpg"} {
+		u, _ := url.Parse("https://example.net/" + name)
+		dls[i] = &download.Download{Addr: u}
+		p.Queued(dls[i])
+	}
+	//the speed of a download is computed for at least one second
+	p.Started(dls[0], 2048)
+	p.Transferred(dls[0], 1024)
+	p.Started(dls[1], -1)
+	p.Transferred(dls[1], 512)
+	dls[2].Err = download.HTTPError{StatusCode: 404, Status: "404 Not Found"}
+	p.Finished(dls[2])
+
+	lines := p.render(p.started.Add(2 * time.Second))
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %q", lines)
+	}
+	want := "Page 3/10 | 2 running, 0 queued | 1 done, 1 failed | 1.5 KiB in 2s, 768 B/s"
+	if lines[0] != want {
+		t.Errorf("Expected %q, got %q", want, lines[0])
+	}
+	if want := "  a.jpg  1.0 KiB / 2.0 KiB  50% 1.0 KiB/s"; lines[1] != want {
+		t.Errorf("Expected %q, got %q", want, lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "…  512 B 512 B/s") || len([]rune(lines[2])) >= p.width {
+		t.Errorf("Line was not shortened to the width of %d: %q", p.width, lines[2])
+	}
+
+	//log messages are printed above of the display
+	p.draw()
+	out.Reset()
+	p.Write([]byte("message\n"))
+	if !strings.HasPrefix(out.String(), "\x1b[3F\x1b[Jmessage\nPage 3/10") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
@@ -0,0 +1,259 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrDownloadsFailed is returned by Crawl if at least one download failed.
+var ErrDownloadsFailed = errors.New("Downloads failed")
+
+// Outcomes of a download in the crawl report.
+const (
+	outcomeSuccess     = "success"
+	outcomeSkipped     = "skipped"
+	outcomeDuplicate   = "duplicate"
+	outcomeExcluded    = "excluded"
+	outcomeHTTPError   = "http-error"
+	outcomeRenameError = "rename-error"
+	outcomeExecError   = "exec-error"
+	outcomeCancelled   = "cancelled"
+	outcomeFailed      = "failed"
+)
+
+// reportDownload is the outcome of a single download. It contains everything that is needed to dispatch the
+// download again.
+type reportDownload struct {
+	URL          string `json:"url"`
+	Page         int    `json:"page"`
+	PageURL      string `json:"page_url,omitempty"`
+	Post         string `json:"post,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	Dir          string `json:"dir"`                     //absolute path of the download's directory
+	File         string `json:"file,omitempty"`          //path relative to Dir, empty if the download was not named yet
+	NameTemplate string `json:"name_template,omitempty"` //names the download once the server sent its name, see nameTemplate.partial
+	Outcome      string `json:"outcome,omitempty"`
+	Skipped      string `json:"skipped,omitempty"`
+	DuplicateOf  string `json:"duplicate_of,omitempty"`
+	Status       int    `json:"status,omitempty"` //http status of an http-error
+	Error        string `json:"error,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+}
+
+// failed returns true if the outcome is a failure.
+func (d *reportDownload) failed() bool {
+	switch d.Outcome {
+	case outcomeHTTPError, outcomeRenameError, outcomeExecError, outcomeCancelled, outcomeFailed:
+		return true
+	}
+	return false
+}
+
+// reportCounts counts the outcomes of downloads.
+type reportCounts struct {
+	Downloads  int   `json:"downloads"`
+	Succeeded  int   `json:"succeeded"`
+	Skipped    int   `json:"skipped"`
+	Duplicates int   `json:"duplicates"`
+	Excluded   int   `json:"excluded"`
+	Failed     int   `json:"failed"`
+	Bytes      int64 `json:"bytes"`
+}
+
+func (c *reportCounts) count(d *reportDownload) {
+	c.Downloads++
+	switch {
+	case d.Outcome == outcomeSuccess:
+		c.Succeeded++
+	case d.Outcome == outcomeSkipped:
+		c.Skipped++
+	case d.Outcome == outcomeDuplicate:
+		c.Duplicates++
+	case d.Outcome == outcomeExcluded:
+		c.Excluded++
+	case d.failed():
+		c.Failed++
+	}
+	if d.Outcome == outcomeSuccess || d.Outcome == outcomeDuplicate {
+		c.Bytes += d.Size
+	}
+}
+
+// reportPage contains the counts of a single page.
+type reportPage struct {
+	Page int    `json:"page"`
+	URL  string `json:"url,omitempty"`
+	reportCounts
+}
+
+// crawlReport collects the outcome of every download of a crawl.
+type crawlReport struct {
+	mu        sync.Mutex
+	path      string            //file the report is written to, empty if it is only summarised
+	Thread    string            `json:"thread,omitempty"`
+	Output    string            `json:"output"`
+	Started   time.Time         `json:"started"`
+	Finished  time.Time         `json:"finished"`
+	Summary   reportCounts      `json:"summary"`
+	Pages     []*reportPage     `json:"pages"`
+	Downloads []*reportDownload `json:"downloads"`
+	pages     map[int]*reportPage
+}
+
+func newCrawlReport(path, output string, thread *url.URL, started time.Time) *crawlReport {
+	r := &crawlReport{path: path, Output: output, Started: started, Downloads: make([]*reportDownload, 0), pages: make(map[int]*reportPage)}
+	if thread != nil {
+		r.Thread = thread.String()
+	}
+	return r
+}
+
+// page returns the counts of page, it must be called with r.mu held.
+func (r *crawlReport) page(page int) *reportPage {
+	p := r.pages[page]
+	if p == nil {
+		p = &reportPage{Page: page}
+		r.pages[page] = p
+	}
+	return p
+}
+
+// pageCrawled notes the address of a page, addr may be nil.
+func (r *crawlReport) pageCrawled(page int, addr *url.URL) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.page(page)
+	if addr != nil {
+		p.URL = addr.String()
+	}
+}
+
+// newReportDownload returns an entry that contains everything that is needed to dispatch dl again.
+func newReportDownload(dl *download.Download) *reportDownload {
+	d := &reportDownload{
+		URL:          dl.Addr.String(),
+		Page:         dl.Page,
+		Post:         dl.PostID,
+		Reason:       dl.Reason,
+		Dir:          dl.Dir(),
+		NameTemplate: dl.NameTemplate,
+	}
+	if dl.PageAddr != nil {
+		d.PageURL = dl.PageAddr.String()
+	}
+	if dl.Named() {
+		d.File = dl.File()
+	}
+	return d
+}
+
+// add records the outcome of the collected download dl.
+func (r *crawlReport) add(dl *download.Download) {
+	d := newReportDownload(dl)
+	d.Outcome = outcomeSuccess
+	var httpErr download.HTTPError
+	var renameErr download.RenameError
+	if dl.Err != nil {
+		d.Error = dl.Err.Error()
+	}
+	switch {
+	case errors.As(dl.Err, &httpErr):
+		d.Outcome, d.Status = outcomeHTTPError, httpErr.StatusCode
+	case errors.As(dl.Err, &renameErr):
+		d.Outcome = outcomeRenameError
+		if inner := renameErr.Unwrap(); inner != nil {
+			d.Error = fmt.Sprintf("%s: %s", renameErr, inner)
+		}
+	case errors.As(dl.Err, new(commandError)):
+		d.Outcome = outcomeExecError
+	case errors.Is(dl.Err, context.Canceled):
+		d.Outcome = outcomeCancelled
+	case dl.Err != nil:
+		d.Outcome = outcomeFailed
+	case dl.Skipped == download.SkipExcluded:
+		d.Outcome = outcomeExcluded
+	case dl.Skipped == download.SkipDuplicate:
+		d.Outcome, d.DuplicateOf = outcomeDuplicate, dl.Duplicate()
+	case dl.Skipped != download.SkipNone:
+		d.Outcome = outcomeSkipped
+	case dl.Duplicate() != "":
+		d.Outcome, d.DuplicateOf = outcomeDuplicate, dl.Duplicate()
+	}
+	if dl.Err == nil && dl.Skipped != download.SkipNone {
+		d.Skipped = dl.Skipped.String()
+	} else if dl.Err == nil {
+		d.Size = dl.Size()
+		d.SHA256 = hex.EncodeToString(dl.SHA256())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Downloads = append(r.Downloads, d)
+	r.page(d.Page).count(d)
+	r.Summary.count(d)
+}
+
+// failures returns the number of failed downloads.
+func (r *crawlReport) failures() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Summary.Failed
+}
+
+// finish prints the summary to w and writes the report file if one was requested.
+func (r *crawlReport) finish(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now()
+	r.Pages = make([]*reportPage, 0, len(r.pages))
+	for _, p := range r.pages {
+		r.Pages = append(r.Pages, p)
+	}
+	sort.Slice(r.Pages, func(i, j int) bool { return r.Pages[i].Page < r.Pages[j].Page })
+	s := &r.Summary
+	fmt.Fprintf(w, "Crawled %d pages in %s: %d downloads, %d succeeded (%s), %d skipped, %d duplicates, %d excluded, %d failed\n",
+		len(r.Pages), r.Finished.Sub(r.Started).Round(time.Second), s.Downloads, s.Succeeded, formatBytes(s.Bytes),
+		s.Skipped, s.Duplicates, s.Excluded, s.Failed)
+	if r.path == "" {
+		return nil
+	}
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// formatBytes formats n with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
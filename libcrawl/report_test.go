@@ -0,0 +1,98 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCrawlReport(t *testing.T) {
+	dir := t.TempDir()
+	page, _ := url.Parse("https://example.net/thread.php?page=2")
+	r := newCrawlReport(filepath.Join(dir, "report.json"), dir, page, time.Now())
+	var tests = []struct {
+		err     error
+		skipped download.SkipReason
+		outcome string
+	}{
+		{nil, download.SkipNone, outcomeSuccess},
+		{download.HTTPError{StatusCode: 404, Status: "404 Not Found"}, download.SkipNone, outcomeHTTPError},
+		{download.NewRenameError("a", "b", os.ErrExist), download.SkipNone, outcomeRenameError},
+		{commandError{name: "sh", err: errors.New("exit status 1")}, download.SkipNone, outcomeExecError},
+		{fmt.Errorf("Copy: %w", context.Canceled), download.SkipNone, outcomeCancelled},
+		{nil, download.SkipExcluded, outcomeExcluded},
+		{nil, download.SkipTooSmall, outcomeSkipped},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(fmt.Sprintf("https://example.net/%d.jpg", i))
+		dl := &download.Download{Addr: u, Page: 2, PageAddr: page, Err: test.err, Skipped: test.skipped}
+		if err := dl.SetDir(dir); err != nil {
+			t.Fatal(err)
+		}
+		dl.SetFile(fmt.Sprintf("%d.jpg", i))
+		r.add(dl)
+	}
+	r.pageCrawled(2, page)
+	if n := r.failures(); n != 4 {
+		t.Errorf("Expected 4 failures, got %d", n)
+	}
+	out := new(bytes.Buffer)
+	if err := r.finish(out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() == 0 {
+		t.Error("No summary was printed")
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(crawlReport)
+	if err := json.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Downloads) != len(tests) {
+		t.Fatalf("Expected %d downloads, got %d", len(tests), len(got.Downloads))
+	}
+	for i, test := range tests {
+		d := got.Downloads[i]
+		if d.Outcome != test.outcome || d.Dir != dir || d.File != fmt.Sprintf("%d.jpg", i) || d.PageURL != page.String() {
+			t.Errorf("%d: unexpected entry %+v", i, d)
+		}
+	}
+	if got.Downloads[1].Status != 404 {
+		t.Errorf("Expected status 404, got %d", got.Downloads[1].Status)
+	}
+	want := reportCounts{Downloads: 7, Succeeded: 1, Skipped: 1, Excluded: 1, Failed: 4}
+	if got.Summary != want || len(got.Pages) != 1 || got.Pages[0].reportCounts != want {
+		t.Errorf("Unexpected counts %+v, %+v", got.Summary, got.Pages)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	var tests = []struct {
+		n   int64
+		out string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+	}
+	for _, test := range tests {
+		if got := formatBytes(test.n); got != test.out {
+			t.Errorf("%d: expected %q, got %q", test.n, test.out, got)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// retryPage holds the failed downloads of a single page of a crawl report.
+type retryPage struct {
+	page      int
+	addr      *url.URL //nil if the report doesn't know the page's address
+	downloads []*reportDownload
+}
+
+// loadRetryPages reads the crawl report at p and returns its failed downloads grouped by page.
+func loadRetryPages(p string) (*crawlReport, []*retryPage, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := new(crawlReport)
+	if err := json.Unmarshal(b, report); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", p, err)
+	}
+	byNum := make(map[int]*retryPage)
+	pages := make([]*retryPage, 0)
+	for _, d := range report.Downloads {
+		if !d.failed() {
+			continue
+		}
+		if _, err := url.Parse(d.URL); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", p, err)
+		}
+		if !filepath.IsAbs(d.Dir) {
+			return nil, nil, fmt.Errorf("%s: directory %q of %q is not absolute", p, d.Dir, d.URL)
+		}
+		rp := byNum[d.Page]
+		if rp == nil {
+			rp = &retryPage{page: d.Page}
+			byNum[d.Page] = rp
+			pages = append(pages, rp)
+		}
+		if rp.addr == nil && d.PageURL != "" {
+			rp.addr, _ = url.Parse(d.PageURL)
+		}
+		rp.downloads = append(rp.downloads, d)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].page < pages[j].page })
+	return report, pages, nil
+}
+
+// retryPager yields one page for every page of a crawl report that has failed downloads, PageNum returns the
+// original page number.
+type retryPager struct {
+	pages   []*retryPage
+	current int //index of the current page plus 1
+}
+
+func (r *retryPager) Next() (*url.URL, error) {
+	if r.current >= len(r.pages) {
+		return nil, nil
+	}
+	r.current++
+	p := r.page()
+	if p.addr != nil {
+		return p.addr, nil
+	}
+	//pages without an address are identified by their first download
+	return url.Parse(p.downloads[0].URL)
+}
+
+func (r *retryPager) PageNum() int {
+	if r.current == 0 {
+		return 0
+	}
+	return r.page().page
+}
+
+func (r *retryPager) lastPage() int {
+	if len(r.pages) == 0 {
+		return 0
+	}
+	return r.pages[len(r.pages)-1].page
+}
+
+func (r *retryPager) page() *retryPage {
+	return r.pages[r.current-1]
+}
+
+func (r *retryPager) SetOptions(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("The retry pager has no options")
+	}
+	return nil
+}
+
+func (r *retryPager) SetUrl(string) error {
+	return nil
+}
+
+// retryCrawler dispatches the failed downloads of the retry pager's current page again, the page itself is not loaded.
+type retryCrawler struct {
+	*baseCrawler
+	pager  *retryPager
+	output string //output directory of the report
+}
+
+func (r *retryCrawler) Crawl(u *url.URL) error {
+	p := r.pager.page()
+	r.pageAddr = p.addr
+	for _, d := range p.downloads {
+		if err := r.redispatch(d, r.output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redispatch dispatches the download d of a crawl report or checkpoint again. Its directory is moved from output,
+// the output directory it was recorded with, to the current output directory.
+func (c *baseCrawler) redispatch(d *reportDownload, output string) error {
+	addr, err := url.Parse(d.URL)
+	if err != nil {
+		return err
+	}
+	dl := c.newDownload(addr)
+	dl.Page = d.Page
+	dl.PageAddr = nil
+	if d.PageURL != "" {
+		if dl.PageAddr, err = url.Parse(d.PageURL); err != nil {
+			return err
+		}
+	}
+	dl.PostID = d.Post
+	dl.Reason = d.Reason
+	dl.NameTemplate = d.NameTemplate
+	if d.Outcome == outcomeExecError {
+		//the file was downloaded completely before its command failed
+		dl.OnConflict = download.ConflictOverwrite
+	}
+	dir := rebaseDir(d.Dir, output, c.cc.output)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := dl.SetDir(dir); err != nil {
+		return err
+	}
+	if d.File != "" {
+		dl.SetPath(d.File)
+	} else if d.NameTemplate != "" {
+		tmpl, err := parseNameTemplate(d.NameTemplate)
+		if err != nil {
+			return err
+		}
+		dl.AfterDownload = download.ADNameFromHeaderFunc(func(name string) string {
+			return tmpl.expand(&nameVars{file: name})
+		})
+	}
+	c.dispatch(dl)
+	return nil
+}
+
+// rebaseDir moves dir from the output directory from to the output directory to. Directories outside of from, like
+// the staging directory of an archive, are replaced by to.
+func rebaseDir(dir, from, to string) string {
+	rel, err := filepath.Rel(from, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return to
+	}
+	return filepath.Join(to, rel)
+}
+
+// setRetry loads the crawl report at p and sets up the crawl context to dispatch its failed downloads again.
+// The output directory of the report is used unless keepOutput is true.
+func (cc *CrawlContext) setRetry(p string, keepOutput bool) error {
+	report, pages, err := loadRetryPages(p)
+	if err != nil {
+		return err
+	}
+	if !keepOutput {
+		cc.output = report.Output
+	}
+	if report.Thread != "" {
+		if cc.thread, err = url.Parse(report.Thread); err != nil {
+			return err
+		}
+	}
+	pager := &retryPager{pages: pages}
+	cc.Pager = pager
+	cc.Crawler = &retryCrawler{baseCrawler: newBaseCrawler(cc), pager: pager, output: report.Output}
+	n := 0
+	for _, p := range pages {
+		n += len(p.downloads)
+	}
+	log.Notice(fmt.Sprintf("Retrying %d failed downloads of %d pages", n, len(pages)))
+	return nil
+}
@@ -0,0 +1,120 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRetryFailed(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/attachment.php" {
+			w.Header().Set("Content-Disposition", `attachment; filename="orig.txt"`)
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	old, output := t.TempDir(), t.TempDir()
+	report := &crawlReport{
+		Thread: srv.URL + "/thread",
+		Output: old,
+		Downloads: []*reportDownload{
+			{URL: srv.URL + "/done.txt", Page: 1, Dir: old, File: "done.txt", Outcome: outcomeSuccess},
+			{URL: srv.URL + "/a.txt", Page: 3, Dir: filepath.Join(old, "sub"), File: "a.txt", Outcome: outcomeHTTPError, Status: 503},
+			{URL: srv.URL + "/attachment.php", Page: 2, PageURL: srv.URL + "/thread?page=2", Post: "7", Dir: old,
+				NameTemplate: "7-{name}.{ext}", Outcome: outcomeRenameError},
+			{URL: srv.URL + "/b.txt", Page: 3, Dir: old, File: "b.txt", Outcome: outcomeCancelled},
+		},
+	}
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	b, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(reportPath, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, pages, err := loadRetryPages(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[0].page != 2 || pages[1].page != 3 || len(pages[1].downloads) != 2 {
+		t.Fatalf("Unexpected pages %+v", pages)
+	}
+	if pages[0].addr == nil || pages[0].addr.String() != srv.URL+"/thread?page=2" || pages[1].addr != nil {
+		t.Errorf("Unexpected page addresses %v, %v", pages[0].addr, pages[1].addr)
+	}
+
+	cc := NewRetryContext(t.TempDir())
+	if err := cc.SetOptions([]string{"-o", output, "-retry-failed", reportPath, "-manifest", "false"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Crawl(cc); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sub/a.txt", "7-orig.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(output, filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	if requested["/done.txt"] != 0 {
+		t.Error("A successful download was retried")
+	}
+	if requested["/thread"] != 0 {
+		t.Error("A page was loaded")
+	}
+}
+
+func TestRetryFailedHook(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer srv.Close()
+	output := t.TempDir()
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	//the command fails, the download counts as failed but its file is kept
+	cc := newTestCrawl(t, srv, output, "1", "1", "-report", reportPath, "-exec", "sh -c 'exit 1'")
+	if err := Crawl(cc); !errors.Is(err, ErrDownloadsFailed) {
+		t.Fatalf("Expected ErrDownloadsFailed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(output, "1 - file")); err != nil {
+		t.Error(err)
+	}
+	_, pages, err := loadRetryPages(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := pages[0].downloads[0]; d.Outcome != outcomeExecError {
+		t.Errorf("Expected the outcome %s, got %s", outcomeExecError, d.Outcome)
+	}
+
+	//the retry replaces the file of the failed download
+	cc = NewRetryContext(t.TempDir())
+	if err := cc.SetOptions([]string{"-retry-failed", reportPath, "-manifest", "false", "-on-conflict", "fail"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Crawl(cc); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(output, "1 - file")); err != nil || string(b) != "/file?page=1" {
+		t.Errorf("Unexpected file after the retry: %q, %v", b, err)
+	}
+}
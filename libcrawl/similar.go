@@ -0,0 +1,198 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jwdev42/bbcrawl/libcrawl/download"
+	"github.com/jwdev42/bbcrawl/libcrawl/manifest"
+	"github.com/jwdev42/bbcrawl/libcrawl/phash"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DEFAULT_SIMILAR_REPORT is the name of the similar images report inside of the output directory.
+const DEFAULT_SIMILAR_REPORT = "similar-images.json"
+
+// similarImage is a downloaded image that was hashed by similarImages.
+type similarImage struct {
+	File    string     `json:"file"` //path relative to the output directory
+	URL     string     `json:"url"`
+	Width   int        `json:"width"`
+	Height  int        `json:"height"`
+	Size    int64      `json:"size"`
+	Hash    string     `json:"hash"`
+	Removed bool       `json:"removed,omitempty"`
+	path    string     //absolute path
+	hash    phash.Hash //perceptual hash
+	page    int
+}
+
+// similarReport is the content of the similar images report.
+type similarReport struct {
+	MaxDistance int               `json:"max_distance"`
+	Groups      [][]*similarImage `json:"groups"`
+}
+
+// similarImages collects the perceptual hashes of downloaded images and reports groups of near-duplicates
+// at the end of the crawl.
+type similarImages struct {
+	mu          sync.Mutex
+	maxDistance int    //maximum Hamming distance between hashes of similar images
+	keepLargest bool   //remove all images of a group but the one with the largest resolution
+	report      string //path of the report file
+	images      []*similarImage
+}
+
+// afterDownload hashes dl if it is a JPEG, PNG or GIF image. It is meant to be part of a Download's AfterDownload chain.
+func (s *similarImages) afterDownload(dl *download.Download) {
+	switch dl.ContentType() {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return
+	}
+	f, err := os.Open(dl.Path())
+	if err != nil {
+		log.Error(fmt.Errorf("Similar images: %w", err))
+		return
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		log.Warning(fmt.Sprintf("Similar images: Cannot decode %q: %s", dl.File(), err))
+		return
+	}
+	b := img.Bounds()
+	hash := phash.DHash(img)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images = append(s.images, &similarImage{
+		URL:    dl.Addr.String(),
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		Size:   dl.Size(),
+		Hash:   fmt.Sprintf("%016x", uint64(hash)),
+		path:   dl.Path(),
+		hash:   hash,
+		page:   dl.Page,
+	})
+}
+
+// existing returns the hashed images that still exist. Symbolic links and further links to the same file are left out,
+// so that the members of a group are distinct files.
+func (s *similarImages) existing() []*similarImage {
+	images := make([]*similarImage, 0, len(s.images))
+	infos := make([]os.FileInfo, 0, len(s.images))
+next:
+	for _, img := range s.images {
+		info, err := os.Lstat(img.path)
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		for _, other := range infos {
+			if os.SameFile(info, other) {
+				continue next
+			}
+		}
+		images = append(images, img)
+		infos = append(infos, info)
+	}
+	return images
+}
+
+// finish groups the hashed images, removes all but the largest image of each group if requested and writes the report.
+// dir is the output directory. Removed images are recorded in m, so that later crawls don't download them again,
+// m may be nil.
+func (s *similarImages) finish(dir string, m *manifest.Manifest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	images := s.existing()
+	hashes := make([]phash.Hash, len(images))
+	for i, img := range images {
+		hashes[i] = img.hash
+		if rel, err := filepath.Rel(dir, img.path); err == nil {
+			img.File = filepath.ToSlash(rel)
+		} else {
+			img.File = img.path
+		}
+	}
+	report := &similarReport{MaxDistance: s.maxDistance, Groups: make([][]*similarImage, 0)}
+	for _, indices := range phash.Groups(hashes, s.maxDistance) {
+		group := make([]*similarImage, len(indices))
+		largest := 0
+		for i, index := range indices {
+			group[i] = images[index]
+			if group[i].larger(group[largest]) {
+				largest = i
+			}
+		}
+		if s.keepLargest {
+			kept := group[largest]
+			for _, img := range group {
+				//groups are transitive, images that are only similar to other members are kept
+				if img == kept || img.hash.Distance(kept.hash) > s.maxDistance {
+					continue
+				}
+				s.remove(img, m)
+			}
+		}
+		report.Groups = append(report.Groups, group)
+	}
+	f, err := os.Create(s.report)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(report); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Notice(fmt.Sprintf("Found %d groups of similar images, see %q", len(report.Groups), s.report))
+	return nil
+}
+
+// remove deletes the file of img together with its sidecar file and records it in m if m is not nil.
+func (s *similarImages) remove(img *similarImage, m *manifest.Manifest) {
+	if err := os.Remove(img.path); err != nil {
+		log.Error(fmt.Errorf("Similar images: %w", err))
+		return
+	}
+	os.Remove(img.path + download.SidecarExt)
+	img.Removed = true
+	if m == nil || img.URL == "" {
+		return
+	}
+	e := &manifest.Entry{
+		Kind: manifest.KindRemoved,
+		URL:  img.URL,
+		File: img.File,
+		Size: img.Size,
+		Page: img.page,
+		Time: time.Now(),
+	}
+	if err := m.Add(e); err != nil {
+		log.Error(fmt.Errorf("Manifest: %w", err))
+	}
+}
+
+// larger compares the resolution of two images, the file size decides if it is equal.
+func (img *similarImage) larger(o *similarImage) bool {
+	a, b := img.Width*img.Height, o.Width*o.Height
+	if a != b {
+		return a > b
+	}
+	return img.Size > o.Size
+}
@@ -0,0 +1,72 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"encoding/json"
+	"github.com/jwdev42/bbcrawl/libcrawl/manifest"
+	"github.com/jwdev42/bbcrawl/libcrawl/phash"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSimilarImagesFinish(t *testing.T) {
+	dir := t.TempDir()
+	m, err := manifest.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	s := &similarImages{maxDistance: 2, keepLargest: true, report: filepath.Join(dir, DEFAULT_SIMILAR_REPORT)}
+	images := []struct {
+		name          string
+		width, height int
+		hash          phash.Hash
+	}{
+		{"small.jpg", 320, 240, 0xf0f0},
+		{"other.jpg", 640, 480, 0x0f0f},
+		{"large.jpg", 640, 480, 0xf0f1},
+		//similar to small.jpg, but not to large.jpg
+		{"chained.jpg", 100, 100, 0xf3f0},
+	}
+	for _, img := range images {
+		p := filepath.Join(dir, img.name)
+		if err := os.WriteFile(p, []byte(img.name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s.images = append(s.images, &similarImage{URL: "https://example.net/" + img.name, Width: img.width,
+			Height: img.height, path: p, hash: img.hash})
+	}
+	if err := s.finish(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	for name, exists := range map[string]bool{"small.jpg": false, "other.jpg": true, "large.jpg": true, "chained.jpg": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Errorf("%s: expected existence %v, got %v", name, exists, err)
+		}
+	}
+	b, err := os.ReadFile(s.report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := new(similarReport)
+	if err := json.Unmarshal(b, report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Groups) != 1 || len(report.Groups[0]) != 3 {
+		t.Fatalf("Expected 1 group of 3 images, got %+v", report.Groups)
+	}
+	if g := report.Groups[0]; g[0].File != "small.jpg" || !g[0].Removed || g[1].File != "large.jpg" || g[1].Removed ||
+		g[2].File != "chained.jpg" || g[2].Removed {
+		t.Errorf("Unexpected group %+v, %+v, %+v", g[0], g[1], g[2])
+	}
+	//later crawls skip the removed image
+	if e := m.Lookup("https://example.net/small.jpg"); e == nil || e.Kind != manifest.KindRemoved || e.File != "small.jpg" {
+		t.Errorf("Expected the removed image in the manifest, got %+v", e)
+	}
+	if e := m.Lookup("https://example.net/chained.jpg"); e != nil {
+		t.Errorf("Expected no manifest entry of a kept image, got %+v", e)
+	}
+}
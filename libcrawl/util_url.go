@@ -6,18 +6,8 @@ package libcrawl
 import (
 	"fmt"
 	"net/url"
-	"os"
-	"strings"
 )
 
-func fileNameFromURL(url *url.URL) string {
-	name := smallestSubstrRight(url.Path, "/")
-	if strings.IndexByte(name, os.PathSeparator) > -1 {
-		return ""
-	}
-	return name
-}
-
 // baseURLOnly returns a new url that has the same host and the same scheme as the src argument, but no path and no query string.
 // Panics if the src argument is a relative url or nil.
 func baseURLOnly(src *url.URL) (*url.URL, error) {
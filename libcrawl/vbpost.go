@@ -0,0 +1,187 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"encoding/json"
+	"github.com/jwdev42/bbcrawl/libhtml"
+	"golang.org/x/net/html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// POST_INFO_FILE is the name of the file the metadata of a post is written to inside of the post's directory.
+const POST_INFO_FILE = "post.json"
+
+// vbDateLayouts are the date formats of vBulletin 3 and 4 that are understood, the first one is the default.
+var vbDateLayouts = []string{
+	"01-02-2006, 03:04 PM",
+	"01-02-2006, 15:04",
+	"01-02-2006 03:04 PM",
+	"01/02/2006, 03:04 PM",
+	"02.01.2006, 15:04",
+	"2006-01-02, 15:04",
+	"2006-01-02 15:04",
+}
+
+// vbPostFragment matches the fragment of a link to a post, e.g. in a quote.
+var vbPostFragment = regexp.MustCompile(`^post([0-9]+)$`)
+
+// vbPostInfo is the metadata of a post.
+type vbPostInfo struct {
+	ID          string   `json:"id"`
+	Author      string   `json:"author"`
+	Date        string   `json:"date,omitempty"`      //as shown by the forum
+	Timestamp   string   `json:"timestamp,omitempty"` //Date in ISO 8601 without time zone, empty if the format is unknown
+	Permalink   string   `json:"permalink"`
+	Page        int      `json:"page"`
+	Text        string   `json:"text"`
+	Attachments []string `json:"attachments"`
+}
+
+// info parses the metadata of the post from the markup of vBulletin 3 and 4. page is the address of the page the
+// post was found on, now resolves relative dates like "Yesterday".
+func (r *vbpost) info(page *url.URL, pageNum int, now time.Time) *vbPostInfo {
+	info := &vbPostInfo{
+		ID:          r.id(),
+		Author:      r.author(),
+		Date:        r.date(),
+		Permalink:   r.permalink(page),
+		Page:        pageNum,
+		Attachments: make([]string, 0),
+	}
+	if t, ok := parseVBDate(info.Date, now); ok {
+		info.Timestamp = t.Format("2006-01-02T15:04:05")
+	}
+	if msg := r.message(); msg != nil {
+		info.Text = libhtml.Text(msg)
+	}
+	return info
+}
+
+// vbThreadPosts returns the posts of a page of vBulletin 3 or 4.
+func vbThreadPosts(document *html.Node, page *url.URL, now time.Time) ([]*threadPost, error) {
+	list := vb4PostList(document)
+	posts := make([]*threadPost, 0, len(list))
+	for _, p := range list {
+		post, err := p.threadPost(page, now)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+// threadPost returns the post as it is written by the PostsCrawler.
+func (r *vbpost) threadPost(page *url.URL, now time.Time) (*threadPost, error) {
+	info := r.info(page, 0, now)
+	post := &threadPost{
+		ID:        info.ID,
+		Author:    info.Author,
+		Date:      info.Date,
+		Timestamp: info.Timestamp,
+		Permalink: info.Permalink,
+		Text:      info.Text,
+	}
+	if elem := libhtml.ElementByClass((*html.Node)(r), "username", "bigusername"); elem != nil {
+		post.AuthorID = memberID(libhtml.AttrVal(elem, "href"))
+	}
+	if msg := r.message(); msg != nil {
+		var err error
+		if post.HTML, err = libhtml.InnerHTML(msg); err != nil {
+			return nil, err
+		}
+		//quotes link to the quoted post
+		post.Quotes = linkedPosts(msg, vbPostFragment)
+	}
+	for _, att := range r.attachments() {
+		if href, err := att.href(); err == nil && href != nil {
+			post.Attachments = append(post.Attachments, page.ResolveReference(href).String())
+		}
+	}
+	return post, nil
+}
+
+// message returns the element that contains the text of the post.
+func (r *vbpost) message() *html.Node {
+	return libhtml.ElementByID((*html.Node)(r), "post_message_"+r.id())
+}
+
+// author returns the name of the post's author. vBulletin 4 marks it with the class "username", vBulletin 3 with
+// "bigusername". Guests are shown without a link, vBulletin 3 puts them into the post menu.
+func (r *vbpost) author() string {
+	node := (*html.Node)(r)
+	if elem := libhtml.ElementByClass(node, "username", "bigusername"); elem != nil {
+		return libhtml.Text(elem)
+	}
+	if elem := libhtml.ElementByID(node, "postmenu_"+r.id()); elem != nil {
+		return libhtml.Text(elem)
+	}
+	return ""
+}
+
+// date returns the date of the post as shown by the forum. vBulletin 4 puts it into an element of the class "date",
+// vBulletin 3 into the first header cell of the post.
+func (r *vbpost) date() string {
+	if elem := libhtml.ElementByClass((*html.Node)(r), "date", "thead"); elem != nil {
+		return libhtml.Text(elem)
+	}
+	return ""
+}
+
+// permalink returns the address of the post. It is taken from the post counter, the post's anchor on page is used
+// if there is none.
+func (r *vbpost) permalink(page *url.URL) string {
+	node := (*html.Node)(r)
+	counter := libhtml.ElementByClass(node, "postcounter")
+	if counter == nil {
+		counter = libhtml.ElementByID(node, "postcount"+r.id())
+	}
+	if counter != nil {
+		if href, err := url.Parse(libhtml.AttrVal(counter, "href")); err == nil && href.String() != "" {
+			return page.ResolveReference(href).String()
+		}
+	}
+	return postAnchor(page, "post"+r.id())
+}
+
+// parseVBDate parses a date in one of the vbDateLayouts. "Today" and "Yesterday" are relative to now.
+func parseVBDate(s string, now time.Time) (time.Time, bool) {
+	if day, rest, ok := strings.Cut(s, ","); ok {
+		switch strings.ToLower(day) {
+		case "today":
+			s = now.Format("01-02-2006") + "," + rest
+		case "yesterday":
+			s = now.AddDate(0, 0, -1).Format("01-02-2006") + "," + rest
+		}
+	}
+	return parseDate(s, vbDateLayouts)
+}
+
+// parseDate parses s in the first of layouts that matches.
+func parseDate(s string, layouts []string) (time.Time, bool) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// writePostInfo writes info to the file POST_INFO_FILE in dir, an existing file is replaced.
+func writePostInfo(dir string, info *vbPostInfo) error {
+	b, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, POST_INFO_FILE), append(b, '\n'), 0644)
+}
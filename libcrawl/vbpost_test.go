@@ -0,0 +1,158 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"encoding/json"
+	"golang.org/x/net/html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseTestPosts(t *testing.T, name string) []*vbpost {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	document, err := html.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := vb4PostList(document)
+	if len(posts) == 0 {
+		t.Fatalf("No posts found in %s", name)
+	}
+	return posts
+}
+
+func TestVBPostInfo(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		file string
+		page string
+		want []vbPostInfo
+	}{
+		{"vb4_thread.html", "https://forum.example.net/showthread.php?t=77", []vbPostInfo{
+			{ID: "1001", Author: "jdoe", Date: "01-15-2020, 10:23 AM", Timestamp: "2020-01-15T10:23:00",
+				Permalink: "https://forum.example.net/showthread.php?t=77&p=1001&viewfull=1#post1001",
+				Text:      "Here are the photos\nfrom our holiday."},
+			{ID: "1002", Author: "Visitor", Date: "Yesterday, 08:05 PM", Timestamp: "2020-02-29T20:05:00",
+				Permalink: "https://forum.example.net/showthread.php?t=77&p=1002&viewfull=1#post1002"},
+		}},
+		{"vb3_thread.html", "https://forum.example.net/showthread.php?t=9&page=2", []vbPostInfo{
+			{ID: "2001", Author: "Mechäniker", Date: "03-07-2009, 14:30", Timestamp: "2009-03-07T14:30:00",
+				Permalink: "https://forum.example.net/showpost.php?p=2001&postcount=11",
+				Text:      "Swapped the engine today.\nPictures below."},
+		}},
+	}
+	for _, test := range tests {
+		page, _ := url.Parse(test.page)
+		posts := parseTestPosts(t, test.file)
+		if len(posts) != len(test.want) {
+			t.Fatalf("%s: expected %d posts, got %d", test.file, len(test.want), len(posts))
+		}
+		for i, post := range posts {
+			got := post.info(page, 2, now)
+			want := test.want[i]
+			if got.ID != want.ID || got.Author != want.Author || got.Date != want.Date ||
+				got.Timestamp != want.Timestamp || got.Permalink != want.Permalink || got.Page != 2 {
+				t.Errorf("%s: expected %+v, got %+v", test.file, want, *got)
+			}
+			if want.Text != "" && got.Text != want.Text {
+				t.Errorf("%s: expected text %q, got %q", test.file, want.Text, got.Text)
+			}
+		}
+	}
+}
+
+func TestParseVBDate(t *testing.T) {
+	now := time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"12-24-2020, 06:15 PM", "2020-12-24 18:15"},
+		{"24.12.2020, 18:15", "2020-12-24 18:15"},
+		{"2020-12-24, 18:15", "2020-12-24 18:15"},
+		{"Today, 08:30 AM", "2021-01-01 08:30"},
+		{"Yesterday, 23:59", "2020-12-31 23:59"},
+		{"3 hours ago", ""},
+	}
+	for _, test := range tests {
+		got, ok := parseVBDate(test.date, now)
+		if test.want == "" {
+			if ok {
+				t.Errorf("%q: expected an error, got %v", test.date, got)
+			}
+		} else if !ok || got.Format("2006-01-02 15:04") != test.want {
+			t.Errorf("%q: expected %s, got %v", test.date, test.want, got)
+		}
+	}
+}
+
+func TestVBPostDirs(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "vb4_thread.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/showthread.php" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(strings.ReplaceAll(string(b), `href="attachment.php`, `href="`+srv.URL+`/attachment.php`)))
+			return
+		}
+		w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer srv.Close()
+	output := t.TempDir()
+	cc, err := NewCrawlContext(PAGER_QUERY, CRAWLER_VB_ATTACHMENTS, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.SetOptions([]string{"-manifest", "false", "-fix-ext", "true"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Pager.SetOptions([]string{"-start", "1", "-end", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.SetUrl(srv.URL + "/showthread.php?t=77&page=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Crawler.SetOptions([]string{"-post-dirs", "true"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Crawl(cc); err != nil {
+		t.Fatal(err)
+	}
+	//the extension of the attachments is corrected from the content type
+	for _, name := range []string{"1-attachment.txt", "2-attachment.txt"} {
+		if _, err := os.Stat(filepath.Join(output, "1001", name)); err != nil {
+			t.Error(err)
+		}
+	}
+	//posts without attachments get no directory
+	if _, err := os.Stat(filepath.Join(output, "1002")); err == nil {
+		t.Error("Directory was created for a post without attachments")
+	}
+	b, err = os.ReadFile(filepath.Join(output, "1001", POST_INFO_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := new(vbPostInfo)
+	if err := json.Unmarshal(b, info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Author != "jdoe" || info.Page != 1 || len(info.Attachments) != 2 ||
+		info.Attachments[0] != srv.URL+"/attachment.php?attachmentid=501&d=1579083780" {
+		t.Errorf("Unexpected post info %+v", info)
+	}
+}
@@ -0,0 +1,130 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package libcrawl
+
+import (
+	"github.com/jwdev42/bbcrawl/libhtml"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var xfPostID = regexp.MustCompile(`^post-[0-9]+$`)
+
+// xfPostFragment matches the fragment of a link to a post.
+var xfPostFragment = regexp.MustCompile(`^post-([0-9]+)$`)
+
+// xfQuoteSource matches the attribute data-source of a quote, its group is the ID of the quoted post.
+var xfQuoteSource = regexp.MustCompile(`^post: ?([0-9]+)$`)
+
+// xfAttachmentPath matches the path of an attachment like "/attachments/photo-jpg.55/", its group is the ID.
+var xfAttachmentPath = regexp.MustCompile(`(?:^|/)attachments/[^/]*\.([0-9]+)/?$`)
+
+// xfPost is a post of XenForo 1 or 2.
+type xfPost html.Node
+
+// xfThreadPosts returns the posts of a page of XenForo. XenForo 2 names the post in the attribute data-content,
+// XenForo 1 in the id.
+func xfThreadPosts(document *html.Node, page *url.URL, now time.Time) ([]*threadPost, error) {
+	nodes := libhtml.ElementsByAttrMatch(document, "data-content", xfPostID)
+	if len(nodes) == 0 {
+		nodes = libhtml.ElementsByAttrMatch(document, "id", xfPostID)
+	}
+	posts := make([]*threadPost, 0, len(nodes))
+	for _, n := range nodes {
+		post, err := (*xfPost)(n).threadPost(page)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+func (r *xfPost) id() string {
+	node := (*html.Node)(r)
+	id := libhtml.AttrVal(node, "data-content")
+	if id == "" {
+		id = libhtml.AttrVal(node, "id")
+	}
+	return strings.TrimPrefix(id, "post-")
+}
+
+// threadPost returns the post as it is written by the PostsCrawler.
+func (r *xfPost) threadPost(page *url.URL) (*threadPost, error) {
+	node := (*html.Node)(r)
+	post := &threadPost{
+		ID:        r.id(),
+		Author:    libhtml.AttrVal(node, "data-author"),
+		Permalink: r.permalink(page),
+	}
+	if elem := libhtml.ElementByClass(node, "username"); elem != nil {
+		if post.Author == "" {
+			post.Author = libhtml.Text(elem)
+		}
+		if post.AuthorID = libhtml.AttrVal(elem, "data-user-id"); post.AuthorID == "" {
+			post.AuthorID = memberID(libhtml.AttrVal(elem, "href"))
+		}
+	}
+	r.date(post)
+	if body := libhtml.ElementByClass(node, "bbWrapper", "messageText"); body != nil {
+		var err error
+		if post.HTML, err = libhtml.InnerHTML(body); err != nil {
+			return nil, err
+		}
+		post.Text = libhtml.Text(body)
+		post.Quotes = linkedPosts(body, xfPostFragment)
+		for _, quote := range libhtml.ElementsByAttrMatch(body, "data-source", xfQuoteSource) {
+			post.Quotes = appendUnique(post.Quotes, xfQuoteSource.FindStringSubmatch(libhtml.AttrVal(quote, "data-source"))[1])
+		}
+	}
+	post.Attachments = attachmentLinks(node, page, func(u *url.URL) string {
+		if m := xfAttachmentPath.FindStringSubmatch(u.Path); m != nil {
+			return m[1]
+		}
+		return ""
+	})
+	return post, nil
+}
+
+// date sets the date of post. XenForo 2 puts it into a time element, XenForo 1 into an element of the class
+// "DateTime" that carries the Unix time in data-time.
+func (r *xfPost) date(post *threadPost) {
+	node := (*html.Node)(r)
+	if times := libhtml.ElementsByTag(node, atom.Time); len(times) > 0 {
+		post.Date = libhtml.Text(times[0])
+		if t, err := time.Parse("2006-01-02T15:04:05-0700", libhtml.AttrVal(times[0], "datetime")); err == nil {
+			post.Timestamp = t.Format(time.RFC3339)
+		}
+	} else if elem := libhtml.ElementByClass(node, "DateTime"); elem != nil {
+		//the title holds the full date if the text is shortened
+		if post.Date = libhtml.AttrVal(elem, "title"); post.Date == "" {
+			post.Date = libhtml.Text(elem)
+		}
+		if sec, err := strconv.ParseInt(libhtml.AttrVal(elem, "data-time"), 10, 64); err == nil {
+			post.Timestamp = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+		}
+	}
+}
+
+// permalink returns the address of the post, taken from the first link to "post-ID" or "posts/ID". The post's
+// anchor on page is used if there is none.
+func (r *xfPost) permalink(page *url.URL) string {
+	id := r.id()
+	for _, a := range libhtml.ElementsByTag((*html.Node)(r), atom.A) {
+		u, err := url.Parse(libhtml.AttrVal(a, "href"))
+		if err != nil {
+			continue
+		}
+		p := strings.TrimSuffix(u.Path, "/")
+		if strings.HasSuffix(p, "/post-"+id) || p == "posts/"+id || strings.HasSuffix(p, "/posts/"+id) {
+			return page.ResolveReference(u).String()
+		}
+	}
+	return postAnchor(page, "post-"+id)
+}
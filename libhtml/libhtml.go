@@ -4,6 +4,8 @@ import (
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 	"regexp"
+	"strings"
+	"unicode"
 )
 
 type nodecollection struct {
@@ -140,3 +142,108 @@ func MatchAttrs(node *html.Node, attr ...html.Attribute) bool {
 	}
 	return true
 }
+
+// HasClass returns true if the class attribute of node contains class.
+func HasClass(node *html.Node, class string) bool {
+	for _, c := range strings.Fields(AttrVal(node, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// ElementByClass returns the first element below of n, n included, that has one of the given classes.
+func ElementByClass(n *html.Node, class ...string) *html.Node {
+	var elem *html.Node
+	byClass := func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+		for _, c := range class {
+			if HasClass(n, c) {
+				elem = n
+				return false
+			}
+		}
+		return true
+	}
+	walkTree(n, byClass, nil)
+	return elem
+}
+
+// Text returns the text content of n as it would be rendered: runs of white space are collapsed into a single space,
+// line breaks and block elements start a new line. Scripts and styles are left out.
+func Text(n *html.Node) string {
+	b := new(strings.Builder)
+	space := false
+	lineStart := func() bool {
+		s := b.String()
+		return s == "" || s[len(s)-1] == '\n'
+	}
+	newline := func() {
+		space = false
+		if !lineStart() {
+			b.WriteByte('\n')
+		}
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			for _, r := range n.Data {
+				if unicode.IsSpace(r) {
+					space = true
+					continue
+				}
+				if space && !lineStart() {
+					b.WriteByte(' ')
+				}
+				space = false
+				b.WriteRune(r)
+			}
+			return
+		case html.ElementNode:
+			switch n.DataAtom {
+			case atom.Script, atom.Style:
+				return
+			case atom.Br:
+				space = false
+				b.WriteByte('\n')
+				return
+			}
+		}
+		block := n.Type == html.ElementNode && blockElements[n.DataAtom]
+		if block {
+			newline()
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			newline()
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(b.String())
+}
+
+// InnerHTML returns the markup of the children of n.
+func InnerHTML(n *html.Node) (string, error) {
+	b := new(strings.Builder)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(b, c); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// blockElements are the elements Text puts on lines of their own.
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true, atom.Dd: true, atom.Div: true,
+	atom.Dl: true, atom.Dt: true, atom.Fieldset: true, atom.Figcaption: true, atom.Figure: true, atom.Footer: true,
+	atom.Form: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Header: true, atom.Hr: true, atom.Li: true, atom.Main: true, atom.Nav: true, atom.Ol: true, atom.P: true,
+	atom.Pre: true, atom.Section: true, atom.Table: true, atom.Td: true, atom.Th: true, atom.Tr: true, atom.Ul: true,
+}
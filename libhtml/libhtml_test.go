@@ -2,6 +2,7 @@ package libhtml
 
 import (
 	"golang.org/x/net/html"
+	"strings"
 	"testing"
 )
 
@@ -30,3 +31,51 @@ func TestMatchAttrs(t *testing.T) {
 		t.Error("A match against an empty slice should always return true")
 	}
 }
+
+func TestText(t *testing.T) {
+	var tests = []struct {
+		input, text string
+	}{
+		{"<div>  Hello\n\t <b>World</b>! </div>", "Hello World!"},
+		{"<div>first<br>second<br/><br>fourth</div>", "first\nsecond\n\nfourth"},
+		{"<div>intro<blockquote>quote <i>text</i></blockquote>reply<script>var x;</script></div>", "intro\nquote text\nreply"},
+		{"<span>01-15-2020,&nbsp;<span>10:23 AM</span></span>", "01-15-2020, 10:23 AM"},
+	}
+	for _, test := range tests {
+		doc, err := html.Parse(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text := Text(doc); text != test.text {
+			t.Errorf("%s: expected %q, got %q", test.input, test.text, text)
+		}
+	}
+}
+
+func TestElementByClass(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<div class="a"><span class="user  name">x</span><a class="username">y</a></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elem := ElementByClass(doc, "username", "name")
+	if elem == nil || elem.Data != "span" || !HasClass(elem, "user") {
+		t.Errorf("Expected the span, got %v", elem)
+	}
+	if elem := ElementByClass(doc, "missing"); elem != nil {
+		t.Errorf("Expected no element, got %v", elem)
+	}
+}
+
+func TestInnerHTML(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<div id="msg">Hello <b>World</b><br>&amp; bye</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := InnerHTML(ElementByID(doc, "msg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello <b>World</b><br/>&amp; bye"; inner != want {
+		t.Errorf("Expected %q, got %q", want, inner)
+	}
+}
@@ -0,0 +1,143 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+// Package ratelimit provides token buckets that can be shared between goroutines to limit the rate of http requests.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket that is refilled with rate tokens per second up to a maximum of burst tokens.
+type Bucket struct {
+	mu     *sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full Bucket. Panics if rate is not positive or if burst is less than 1.
+func NewBucket(rate float64, burst int) *Bucket {
+	if rate <= 0 {
+		panic("rate must be > 0")
+	}
+	if burst < 1 {
+		panic("burst must be > 0")
+	}
+	return &Bucket{mu: new(sync.Mutex), rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait takes a single token from the bucket, see WaitN.
+func (b *Bucket) Wait(ctx context.Context) error {
+	return b.WaitN(ctx, 1)
+}
+
+// WaitN takes n tokens from the bucket and blocks until they are available or until ctx is done. If ctx is done
+// first, the tokens are given back. n may exceed the bucket's burst size, the bucket will then be in debt and the
+// following callers have to wait longer.
+func (b *Bucket) WaitN(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens += float64(n)
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Limiter limits the rate of requests globally and per host. A nil Limiter does not limit anything.
+type Limiter struct {
+	global *Bucket
+	hosts  map[string]*Bucket
+}
+
+// NewLimiter returns a Limiter that allows rate requests per second in total, rate 0 means unlimited.
+// The map hosts contains additional limits in requests per second for single hosts.
+func NewLimiter(rate float64, hosts map[string]float64) *Limiter {
+	l := &Limiter{hosts: make(map[string]*Bucket, len(hosts))}
+	if rate > 0 {
+		l.global = NewBucket(rate, 1)
+	}
+	for host, hrate := range hosts {
+		l.hosts[strings.ToLower(host)] = NewBucket(hrate, 1)
+	}
+	return l
+}
+
+// Wait blocks until a request to u is allowed by the global limit and by the limit of u's host.
+func (l *Limiter) Wait(ctx context.Context, u *url.URL) error {
+	if l == nil {
+		return nil
+	}
+	if b := l.hosts[strings.ToLower(u.Hostname())]; b != nil {
+		if err := b.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if l.global != nil {
+		return l.global.Wait(ctx)
+	}
+	return nil
+}
+
+// Reader is an io.Reader that takes a token from a shared Bucket for every byte it reads.
+type Reader struct {
+	ctx   context.Context
+	r     io.Reader
+	b     *Bucket
+	chunk int
+}
+
+// NewReader returns a Reader that reads from r at the rate of bucket b. Reading fails if ctx is done.
+// If b is nil, r is returned unchanged.
+func NewReader(ctx context.Context, r io.Reader, b *Bucket) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &Reader{ctx: ctx, r: r, b: b, chunk: int(b.burst)}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.b.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,69 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(100, 1)
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("11 tokens at 100/s took %s, expected at least 100ms", elapsed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.WaitN(ctx, 1000); err == nil {
+		t.Error("Expected an error from a cancelled context")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.WaitN(ctx, 1000); err == nil {
+		t.Error("Expected an error from a context that timed out")
+	}
+	//the tokens of the cancelled calls were given back
+	start = time.Now()
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("A token after cancelled calls took %s", elapsed)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	u, _ := url.Parse("http://example.net")
+	if err := l.Wait(context.Background(), u); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	const size = 3000
+	src := bytes.NewReader(make([]byte, size))
+	r := NewReader(context.Background(), src, NewBucket(10000, 1000))
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != size {
+		t.Errorf("Expected %d bytes, got %d", size, n)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Reading %d bytes at 10000 B/s took %s, expected at least 150ms", size, elapsed)
+	}
+}
@@ -0,0 +1,132 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+// Package sigv4 signs http requests with the AWS Signature Version 4, as required by S3-compatible object storage.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+)
+
+// EmptyPayload is the hex encoded SHA-256 of an empty request body.
+const EmptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Credentials are the keys a request is signed with. SessionToken is only needed for temporary credentials.
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// Sign adds the X-Amz-Date and Authorization headers to req. payloadHash is the hex encoded SHA-256 of the request
+// body, region and service make up the credential scope together with t. The host and the Content-Type and X-Amz-*
+// headers are signed, so they must not be changed afterwards.
+func Sign(req *http.Request, payloadHash string, creds Credentials, region, service string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(timeFormat))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	signed, canonicalHeaders := canonicalHeaders(req)
+	canonical := strings.Join([]string{
+		req.Method,
+		Escape(req.URL.Path, false),
+		canonicalQuery(req),
+		canonicalHeaders,
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{t.Format(dateFormat), region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{algorithm, t.Format(timeFormat), scope, hashHex([]byte(canonical))}, "\n")
+	key := []byte("AWS4" + creds.SecretKey)
+	for _, part := range []string{t.Format(dateFormat), region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, creds.AccessKey, scope, signed, signature))
+}
+
+// canonicalHeaders returns the list of signed header names and the canonical form of these headers.
+func canonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for name, v := range req.Header {
+		name = strings.ToLower(name)
+		if name != "content-type" && !strings.HasPrefix(name, "x-amz-") {
+			continue
+		}
+		trimmed := make([]string, len(v))
+		for i := range v {
+			trimmed[i] = strings.Join(strings.Fields(v[i]), " ")
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := new(strings.Builder)
+	for _, name := range names {
+		fmt.Fprintf(b, "%s:%s\n", name, values[name])
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, Escape(name, true)+"="+Escape(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// Escape percent-encodes every byte of s but the unreserved characters A-Z, a-z, 0-9, '-', '.', '_' and '~'.
+// Slashes are only encoded if encodeSlash is true.
+func Escape(s string, encodeSlash bool) string {
+	b := new(strings.Builder)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,43 @@
+/* This file is part of bbcrawl, ©2020 Jörg Walter
+ *  This software is licensed under the "GNU General Public License version 3" */
+
+package sigv4
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestSign uses the "get-vanilla" case of the AWS Signature Version 4 test suite.
+func TestSign(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	Sign(req, EmptyPayload, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	const want = "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("Unexpected date %q", got)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	var tests = []struct {
+		in, out     string
+		encodeSlash bool
+	}{
+		{"/bucket/a b/ü+(1).jpg", "/bucket/a%20b/%C3%BC%2B%281%29.jpg", false},
+		{"a/b~c", "a%2Fb~c", true},
+	}
+	for _, test := range tests {
+		if got := Escape(test.in, test.encodeSlash); got != test.out {
+			t.Errorf("%q: expected %q, got %q", test.in, test.out, got)
+		}
+	}
+}